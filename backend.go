@@ -0,0 +1,54 @@
+package mixer
+
+// Backend is an audio output device that the mixer writes its mixed sound data
+// to. The device continuously plays a ring buffer of bytes in a loop. The mixer
+// polls the current play and write positions and writes new sound data right
+// at the write cursor.
+//
+// Init calls Open first and then Start. After that, the mixer calls Positions
+// and Write periodically from its own Go routine. Close calls Stop and Close.
+type Backend interface {
+	// Open prepares the device for playing sound data in the given format. The
+	// bufferSize is the requested size of the ring buffer in bytes. The ring
+	// buffer is not played until Start is called.
+	Open(f Format, bufferSize uint) error
+
+	// BufferSize returns the actual size of the ring buffer in bytes. It might
+	// differ from the requested size. It is only called after Open succeeded.
+	BufferSize() uint
+
+	// Positions returns the play and write cursors. These are byte offsets
+	// into the ring buffer. The region between the two is commited to the
+	// device and must not be written to. Data can safely be written starting
+	// at the write cursor.
+	Positions() (play, write uint, err error)
+
+	// Write copies the given data into the ring buffer, starting at the given
+	// byte offset. Writing outside the bounds of the buffer wraps around and
+	// continues writing at the beginning.
+	Write(data []byte, offset uint) error
+
+	// Start starts playing the ring buffer in a loop.
+	Start() error
+
+	// Stop stops playing the ring buffer.
+	Stop() error
+
+	// Close releases all resources of the device.
+	Close() error
+}
+
+// Format describes the PCM data that the mixer writes to a Backend. Samples
+// are interleaved, e.g. for 2 channels the layout is:
+//
+//	channel1[0] channel2[0] channel1[1] channel2[1] ...
+type Format struct {
+	SamplesPerSecond int
+	ChannelCount     int
+	SampleFormat     SampleFormat
+}
+
+// FrameSize returns the number of bytes that one sample of all channels takes.
+func (f Format) FrameSize() int {
+	return f.ChannelCount * f.SampleFormat.BitsPerSample() / 8
+}
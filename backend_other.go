@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package mixer
+
+import "errors"
+
+func defaultBackend() (Backend, error) {
+	return nil, errors.New("mixer.Init: no default backend on this platform")
+}
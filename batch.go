@@ -0,0 +1,124 @@
+package mixer
+
+import "time"
+
+// Tx collects changes to sounds that are applied together by Mixer.Batch.
+// There is a method for every function of Sound that changes it. They only
+// record the changes, they do not take effect until the Batch function
+// returns.
+type Tx struct {
+	mixer   *Mixer
+	changes []txChange
+}
+
+type txChange struct {
+	sound *sound
+	change
+	// seq is the number of the change for the sound
+	seq uint64
+}
+
+// Batch calls f to collect changes to sounds and then applies all of them at
+// once. They take effect at the same output sample, so related sounds never
+// change in different mixes. The changes are posted to the mixer as a single
+// command, which is cheaper than calling the Sound functions for many sounds.
+// Changes to Stopped sounds or sounds of other mixers are ignored.
+func (m *Mixer) Batch(f func(tx *Tx)) {
+	tx := &Tx{mixer: m}
+	f(tx)
+
+	var changes []txChange
+	for _, c := range tx.changes {
+		if seq, ok := c.sound.expect(c.expect); ok {
+			c.seq = seq
+			changes = append(changes, c)
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	m.post(func() {
+		for _, c := range changes {
+			c.sound.applyChange(c.apply, c.seq)
+		}
+	})
+}
+
+func (tx *Tx) add(s Sound, c change) {
+	if s, ok := s.(*sound); ok && s.mixer == tx.mixer {
+		tx.changes = append(tx.changes, txChange{sound: s, change: c})
+	}
+}
+
+// SetPaused records a call to Sound.SetPaused.
+func (tx *Tx) SetPaused(s Sound, paused bool) {
+	tx.add(s, pausedChange(paused))
+}
+
+// SetPausedAt records a call to Sound.SetPausedAt.
+func (tx *Tx) SetPausedAt(s Sound, paused bool, t int64) {
+	tx.add(s, pausedAtChange(paused, t))
+}
+
+// SetVolume records a call to Sound.SetVolume.
+func (tx *Tx) SetVolume(s Sound, v float32) {
+	tx.add(s, volumeChange(v))
+}
+
+// SetVolumeAt records a call to Sound.SetVolumeAt.
+func (tx *Tx) SetVolumeAt(s Sound, v float32, t int64) {
+	tx.add(s, volumeAtChange(v, t))
+}
+
+// SetPan records a call to Sound.SetPan.
+func (tx *Tx) SetPan(s Sound, p float32) {
+	tx.add(s, panChange(p))
+}
+
+// SetPanAt records a call to Sound.SetPanAt.
+func (tx *Tx) SetPanAt(s Sound, p float32, t int64) {
+	tx.add(s, panAtChange(p, t))
+}
+
+// SetPitch records a call to Sound.SetPitch.
+func (tx *Tx) SetPitch(s Sound, p float32) {
+	tx.add(s, pitchChange(p))
+}
+
+// SetPosition records a call to Sound.SetPosition.
+func (tx *Tx) SetPosition(s Sound, pos time.Duration) {
+	tx.add(s, positionChange(pos))
+}
+
+// ReleaseLoop records a call to Sound.ReleaseLoop.
+func (tx *Tx) ReleaseLoop(s Sound) {
+	tx.add(s, releaseLoopChange())
+}
+
+// FadeTo records a call to Sound.FadeTo.
+func (tx *Tx) FadeTo(s Sound, v float32, d time.Duration, curve Curve) {
+	tx.add(s, fadeChange(v, d, curve, false))
+}
+
+// FadeOutAndStop records a call to Sound.FadeOutAndStop.
+func (tx *Tx) FadeOutAndStop(s Sound, d time.Duration) {
+	tx.add(s, fadeOutAndStopChange(d))
+}
+
+// SetGroup records a call to Sound.SetGroup.
+func (tx *Tx) SetGroup(s Sound, g Group) {
+	if own, ok := tx.mixer.ownGroup(g); ok {
+		tx.add(s, groupChange(own))
+	}
+}
+
+// Stop records a call to Sound.Stop.
+func (tx *Tx) Stop(s Sound) {
+	tx.add(s, stopChange())
+}
+
+// StopAt records a call to Sound.StopAt.
+func (tx *Tx) StopAt(s Sound, t int64) {
+	tx.add(s, stopAtChange(t))
+}
@@ -0,0 +1,49 @@
+package mixer
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock returns the time of the output that is currently heard, counted from
+// Init. Unlike the data that the mixer writes ahead, this is what the listener
+// hears right now, so use it to synchronize e.g. graphics to the sound. While
+// the mixer runs in real time, the clock is extrapolated from the last update
+// so it advances smoothly between updates.
+func (m *Mixer) Clock() time.Duration {
+	clock := m.view()
+	if clock == nil {
+		return 0
+	}
+	return samplesToDuration(float64(m.now(clock)), clock.samplesPerSecond)
+}
+
+// Now returns the mixer clock in output samples per channel, counted from
+// Init. This is the sample that is currently heard, see Clock. It never goes
+// back until the mixer is initialized again.
+//
+// Use it to schedule sounds and changes at exact samples, e.g. with
+// SoundSource.PlayAt. Times that passed before the next update are applied
+// right away, so to be exact, schedule at least the Latency ahead of Now.
+func (m *Mixer) Now() int64 {
+	clock := m.view()
+	if clock == nil {
+		return 0
+	}
+	return m.now(clock)
+}
+
+// now returns the sample that is heard at the given clock, but never one
+// before the last value that it returned.
+func (m *Mixer) now(clock *mixerView) int64 {
+	now := int64(float64(clock.frames) - clock.audibleDelay())
+	for {
+		last := atomic.LoadInt64(&m.lastNow)
+		if now <= last {
+			return last
+		}
+		if atomic.CompareAndSwapInt64(&m.lastNow, last, now) {
+			return now
+		}
+	}
+}
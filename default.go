@@ -0,0 +1,106 @@
+package mixer
+
+import (
+	"io"
+	"time"
+
+	"github.com/gonutz/mixer/wav"
+)
+
+// std is the default Mixer that the package level functions use.
+var std = New()
+
+// Default returns the default Mixer that the package level functions use. Use
+// it to call the Mixer functions that have no package level version, e.g.
+// Default().Stats().
+func Default() *Mixer {
+	return std
+}
+
+// Init calls Init on the default Mixer.
+func Init(b Backend, opts *Options) error {
+	return std.Init(b, opts)
+}
+
+// InitOffline calls InitOffline on the default Mixer.
+func InitOffline(opts *Options) error {
+	return std.InitOffline(opts)
+}
+
+// Close calls Close on the default Mixer.
+func Close() {
+	std.Close()
+}
+
+// Error returns the last error of the default Mixer.
+func Error() error {
+	return std.Error()
+}
+
+// SetVolume sets the master volume of the default Mixer.
+func SetVolume(v float32) {
+	std.SetVolume(v)
+}
+
+// Render calls Render on the default Mixer.
+func Render(d time.Duration) (*wav.Wave, error) {
+	return std.Render(d)
+}
+
+// RenderTo calls RenderTo on the default Mixer.
+func RenderTo(w io.Writer, d time.Duration) error {
+	return std.RenderTo(w, d)
+}
+
+// NewSoundSource creates a new sound source from the given wave data. All
+// Sounds played from it are output by the default Mixer.
+func NewSoundSource(w *wav.Wave) (SoundSource, error) {
+	return std.NewSoundSource(w)
+}
+
+// Read calls Read on the default Mixer.
+func Read(p []float32) (int, error) {
+	return std.Read(p)
+}
+
+// PCMReader returns an io.Reader for the default Mixer.
+func PCMReader() io.Reader {
+	return std.PCMReader()
+}
+
+// StartRecording calls StartRecording on the default Mixer.
+func StartRecording(w io.WriteSeeker) error {
+	return std.StartRecording(w)
+}
+
+// StopRecording calls StopRecording on the default Mixer.
+func StopRecording() error {
+	return std.StopRecording()
+}
+
+// Latency returns the output latency of the default Mixer.
+func Latency() time.Duration {
+	return std.Latency()
+}
+
+// Clock returns the time of the output that is currently heard from the
+// default Mixer.
+func Clock() time.Duration {
+	return std.Clock()
+}
+
+// Now returns the clock of the default Mixer in output samples.
+func Now() int64 {
+	return std.Now()
+}
+
+// Batch applies changes to sounds of the default Mixer at once, see
+// Mixer.Batch.
+func Batch(f func(tx *Tx)) {
+	std.Batch(f)
+}
+
+// NewGroup creates a group in the default Mixer, see Mixer.NewGroup.
+func NewGroup(name string, parent Group) (Group, error) {
+	return std.NewGroup(name, parent)
+}
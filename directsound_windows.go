@@ -0,0 +1,52 @@
+package mixer
+
+import "github.com/gonutz/mixer/dsound"
+
+// NewDirectSoundBackend returns a Backend that outputs sound through its own
+// DirectSound device. It is the default Backend on Windows.
+func NewDirectSoundBackend() Backend {
+	return &directSound{}
+}
+
+func defaultBackend() (Backend, error) {
+	return NewDirectSoundBackend(), nil
+}
+
+type directSound struct {
+	device dsound.Device
+}
+
+func (d *directSound) Open(f Format, bufferSize uint) error {
+	return d.device.InitFormat(
+		f.SamplesPerSecond,
+		f.ChannelCount,
+		f.SampleFormat.BitsPerSample(),
+		f.SampleFormat == Float32,
+		int(bufferSize),
+	)
+}
+
+func (d *directSound) BufferSize() uint {
+	return d.device.BufferSize()
+}
+
+func (d *directSound) Positions() (play, write uint, err error) {
+	return d.device.GetPlayAndWriteCursors()
+}
+
+func (d *directSound) Write(data []byte, offset uint) error {
+	return d.device.WriteToSoundBuffer(data, offset)
+}
+
+func (d *directSound) Start() error {
+	return d.device.StartSound()
+}
+
+func (d *directSound) Stop() error {
+	return d.device.StopSound()
+}
+
+func (d *directSound) Close() error {
+	d.device.Close()
+	return nil
+}
@@ -3,16 +3,72 @@ package dsound
 import (
 	"errors"
 	"strconv"
+	"unsafe"
 
 	"github.com/gonutz/ds"
 	"github.com/gonutz/w32/v2"
 )
 
+// Device is a DirectSound object with a sound buffer. Each Device can be
+// initialized and played independently. The zero value is ready to be
+// initialized.
+//
+// The package level functions use a global Device.
+type Device struct {
+	directSound   *ds.DirectSound
+	primaryBuffer *ds.Buffer
+	soundBuffer   *ds.Buffer
+	bufferSize    uint32
+}
+
+var global Device
+
+// waveFormatTagExtensible is the format tag of a waveFormatExtensible.
+const waveFormatTagExtensible = 0xFFFE
+
+// waveFormatExtensible is the WAVEFORMATEXTENSIBLE structure that formats
+// with more than 16 bits per sample or more than 2 channels must be described
+// with. Its fields are laid out like in C, ds.WAVEFORMATEXTENSIBLE is not.
+type waveFormatExtensible struct {
+	FormatTag          uint16
+	Channels           uint16
+	SamplesPerSec      uint32
+	AvgBytesPerSec     uint32
+	BlockAlign         uint16
+	BitsPerSample      uint16
+	Size               uint16 // size of the fields after Size in bytes
+	ValidBitsPerSample uint16
+	ChannelMask        uint32
+	SubFormat          ds.GUID
+}
+
+// extensibleSize is the size in bytes of the fields after Size in a
+// waveFormatExtensible.
+const extensibleSize = 22
+
 var (
-	globalDirectSoundObject  *ds.DirectSound
-	globalPrimarySoundBuffer *ds.Buffer
-	globalSoundBuffer        *ds.Buffer
-	globalBufferSize         uint32
+	// subtypePCM is KSDATAFORMAT_SUBTYPE_PCM, for integer samples
+	subtypePCM = ds.GUID{
+		Data1: 0x00000001,
+		Data2: 0x0000,
+		Data3: 0x0010,
+		Data4: [8]byte{0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71},
+	}
+	// subtypeIEEEFloat is KSDATAFORMAT_SUBTYPE_IEEE_FLOAT, for 32 bit
+	// floating point samples
+	subtypeIEEEFloat = ds.GUID{
+		Data1: 0x00000003,
+		Data2: 0x0000,
+		Data3: 0x0010,
+		Data4: [8]byte{0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71},
+	}
+)
+
+// speaker positions for the channel mask
+const (
+	speakerFrontLeft   = 0x1
+	speakerFrontRight  = 0x2
+	speakerFrontCenter = 0x4
 )
 
 // Init sets up DirectSound and creates a sound buffer with 2 channels, 16 bit
@@ -20,16 +76,52 @@ var (
 // call StartSound.
 // Make sure to call Close when you are done with DirectSound.
 func Init(samplesPerSecond int) error {
+	return global.Init(samplesPerSecond)
+}
+
+// Init is like the package level Init but initializes d.
+func (d *Device) Init(samplesPerSecond int) error {
+	return d.InitFormat(samplesPerSecond, 2, 16, false, 0)
+}
+
+// InitFormat is like Init but lets you choose the number of channels, the bits
+// per sample and the size of the sound buffer. If isFloat is true, the samples
+// are 32 bit IEEE floating point numbers and bitsPerSample must be 32.
+// Otherwise they are signed integers and bitsPerSample can be 8, 16 or 24.
+// The bufferSize is in bytes, if it is 0 the buffer holds 2 seconds of sound.
+func InitFormat(samplesPerSecond, channelCount, bitsPerSample int, isFloat bool, bufferSize int) error {
+	return global.InitFormat(samplesPerSecond, channelCount, bitsPerSample, isFloat, bufferSize)
+}
+
+// InitFormat is like the package level InitFormat but initializes d.
+func (d *Device) InitFormat(samplesPerSecond, channelCount, bitsPerSample int, isFloat bool, bufferSize int) error {
 	if samplesPerSecond <= 0 {
 		return errors.New(
 			"initDirectSound: illegal samplesPerSound: " +
 				strconv.Itoa(samplesPerSecond))
 	}
+	if channelCount <= 0 {
+		return errors.New(
+			"initDirectSound: illegal channelCount: " +
+				strconv.Itoa(channelCount))
+	}
+	if isFloat && bitsPerSample != 32 ||
+		!isFloat && !(bitsPerSample == 8 || bitsPerSample == 16 || bitsPerSample == 24) {
+		return errors.New(
+			"initDirectSound: illegal bitsPerSample: " +
+				strconv.Itoa(bitsPerSample))
+	}
+
+	if bufferSize < 0 {
+		return errors.New(
+			"initDirectSound: illegal bufferSize: " +
+				strconv.Itoa(bufferSize))
+	}
 
-	return initDirectSound(samplesPerSecond)
+	return d.initDirectSound(samplesPerSecond, channelCount, bitsPerSample, isFloat, bufferSize)
 }
 
-func initDirectSound(samplesPerSecond int) error {
+func (d *Device) initDirectSound(samplesPerSecond, channelCount, bitsPerSample int, isFloat bool, bufferSize int) error {
 	dsound, err := ds.Create(nil)
 	if err != nil {
 		return err
@@ -47,25 +139,53 @@ func initDirectSound(samplesPerSecond int) error {
 		dsound.Release()
 		return err
 	}
-	format := ds.WAVEFORMATEX{
+	// the primary buffer only supports 8 or 16 bit PCM, DirectSound converts
+	// the secondary buffer's data to it
+	primaryFormat := ds.WAVEFORMATEX{
 		FormatTag:     ds.WAVE_FORMAT_PCM,
-		Channels:      2,
-		SamplesPerSec: 44100,
+		Channels:      uint16(channelCount),
+		SamplesPerSec: uint32(samplesPerSecond),
 		BitsPerSample: 16, // NOTE must be 8 or 16
 	}
-	format.BlockAlign = (format.Channels * format.BitsPerSample) / 8
-	format.AvgBytesPerSec = format.SamplesPerSec * uint32(format.BlockAlign)
-	err = primaryBuffer.SetFormat(format)
+	primaryFormat.BlockAlign = (primaryFormat.Channels * primaryFormat.BitsPerSample) / 8
+	primaryFormat.AvgBytesPerSec = primaryFormat.SamplesPerSec * uint32(primaryFormat.BlockAlign)
+	err = primaryBuffer.SetFormat(primaryFormat)
 	if err != nil {
 		primaryBuffer.Release()
 		dsound.Release()
 		return err
 	}
-	globalBufferSize = 2 * format.AvgBytesPerSec
+	format := waveFormatExtensible{
+		FormatTag:     ds.WAVE_FORMAT_PCM,
+		Channels:      uint16(channelCount),
+		SamplesPerSec: uint32(samplesPerSecond),
+		BitsPerSample: uint16(bitsPerSample),
+	}
+	format.BlockAlign = (format.Channels * format.BitsPerSample) / 8
+	format.AvgBytesPerSec = format.SamplesPerSec * uint32(format.BlockAlign)
+	if bitsPerSample > 16 || channelCount > 2 {
+		// 24 bit, floating point and multi-channel data is not defined by a
+		// plain WAVEFORMATEX
+		format.FormatTag = waveFormatTagExtensible
+		format.Size = extensibleSize
+		format.ValidBitsPerSample = format.BitsPerSample
+		format.ChannelMask = channelMask(channelCount)
+		format.SubFormat = subtypePCM
+		if isFloat {
+			format.SubFormat = subtypeIEEEFloat
+		}
+	}
+	bufferBytes := 2 * format.AvgBytesPerSec
+	if bufferSize > 0 {
+		// make sure the buffer holds whole samples
+		bufferBytes = uint32(bufferSize - bufferSize%int(format.BlockAlign))
+	}
 	secondaryBuffer, err := dsound.CreateSoundBuffer(ds.BUFFERDESC{
 		Flags:       ds.BCAPS_GETCURRENTPOSITION2 | ds.BCAPS_GLOBALFOCUS,
-		BufferBytes: globalBufferSize,
-		WfxFormat:   &format,
+		BufferBytes: bufferBytes,
+		// the WAVEFORMATEX is the start of the waveFormatExtensible, its tag
+		// tells DirectSound whether the extensible fields follow
+		WfxFormat: (*ds.WAVEFORMATEX)(unsafe.Pointer(&format)),
 	})
 	if err != nil {
 		primaryBuffer.Release()
@@ -73,50 +193,89 @@ func initDirectSound(samplesPerSecond int) error {
 		return err
 	}
 
-	globalDirectSoundObject = dsound
-	globalPrimarySoundBuffer = primaryBuffer
-	globalSoundBuffer = secondaryBuffer
+	d.directSound = dsound
+	d.primaryBuffer = primaryBuffer
+	d.soundBuffer = secondaryBuffer
+	d.bufferSize = bufferBytes
 
 	return nil
 }
 
+// channelMask returns the speaker positions for the given number of channels.
+// Mono and stereo data are played on the front speakers, for more channels
+// the mask is 0 and the channels are not assigned to specific speakers.
+func channelMask(channelCount int) uint32 {
+	switch channelCount {
+	case 1:
+		return speakerFrontCenter
+	case 2:
+		return speakerFrontLeft | speakerFrontRight
+	}
+	return 0
+}
+
 // Close releases all resources that were allocated when initializing
 // DirectSound. It will stop playing the sound, if any.
 func Close() {
-	globalBufferSize = 0
-	globalSoundBuffer.Release()
-	globalPrimarySoundBuffer.Release()
-	globalDirectSoundObject.Release()
+	global.Close()
+}
+
+// Close is like the package level Close but for d.
+func (d *Device) Close() {
+	d.bufferSize = 0
+	d.soundBuffer.Release()
+	d.primaryBuffer.Release()
+	d.directSound.Release()
 }
 
 // BufferSize returns the size in bytes of the sound buffer that you write to
 // with WriteToSoundBuffer. When DirectSound is not initialized this value is 0.
 func BufferSize() uint {
-	return uint(globalBufferSize)
+	return global.BufferSize()
+}
+
+// BufferSize is like the package level BufferSize but for d.
+func (d *Device) BufferSize() uint {
+	return uint(d.bufferSize)
 }
 
 // StartSound must be called after initialization to make the sound buffer
 // audible. It will internally call Play on the DirectSound buffer with the
 // looping option so the sound plays forever (until you call StopSound).
 func StartSound() error {
-	return globalSoundBuffer.Play(0, ds.BPLAY_LOOPING)
+	return global.StartSound()
+}
+
+// StartSound is like the package level StartSound but for d.
+func (d *Device) StartSound() error {
+	return d.soundBuffer.Play(0, ds.BPLAY_LOOPING)
 }
 
 // StopSound stops playing the sound buffer.
 func StopSound() error {
-	return globalSoundBuffer.Stop()
+	return global.StopSound()
+}
+
+// StopSound is like the package level StopSound but for d.
+func (d *Device) StopSound() error {
+	return d.soundBuffer.Stop()
 }
 
 // WriteToSoundBuffer locks the sound buffer and writes the given data into it,
 // starting at the given byte offset. The buffer is a ring buffer so writing
 // outside the bounds will wrap around and continue writing to the beginning.
 func WriteToSoundBuffer(data []byte, offset uint) error {
-	mem, err := globalSoundBuffer.Lock(uint32(offset), uint32(len(data)), 0)
+	return global.WriteToSoundBuffer(data, offset)
+}
+
+// WriteToSoundBuffer is like the package level WriteToSoundBuffer but for d.
+func (d *Device) WriteToSoundBuffer(data []byte, offset uint) error {
+	mem, err := d.soundBuffer.Lock(uint32(offset), uint32(len(data)), 0)
 	if err != nil {
 		return err
 	}
 	mem.Write(0, data)
-	return globalSoundBuffer.Unlock(mem)
+	return d.soundBuffer.Unlock(mem)
 }
 
 // GetPlayAndWriteCursors returns the play and write cursors. These are byte
@@ -131,7 +290,13 @@ func WriteToSoundBuffer(data []byte, offset uint) error {
 // You can safely  write sound data starting at the write cursor and ending at
 // the play cursor.
 func GetPlayAndWriteCursors() (play, write uint, err error) {
-	p, w, e := globalSoundBuffer.GetCurrentPosition()
+	return global.GetPlayAndWriteCursors()
+}
+
+// GetPlayAndWriteCursors is like the package level GetPlayAndWriteCursors but
+// for d.
+func (d *Device) GetPlayAndWriteCursors() (play, write uint, err error) {
+	p, w, e := d.soundBuffer.GetCurrentPosition()
 	play = uint(p)
 	write = uint(w)
 	err = e
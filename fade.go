@@ -0,0 +1,90 @@
+package mixer
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Curve is the shape of a fade from one volume to another.
+type Curve int
+
+const (
+	// LinearCurve changes the volume by the same amount in every sample.
+	LinearCurve Curve = iota
+	// ExponentialCurve changes the volume by the same factor in every sample,
+	// which sounds like an even change in loudness. Fades from or to silence
+	// start or end at -60 dB.
+	ExponentialCurve
+	// EqualPowerCurve follows a quarter sine wave. Fading one sound in and
+	// another one out at the same time with this curve keeps the combined
+	// loudness constant.
+	EqualPowerCurve
+)
+
+func (c Curve) String() string {
+	switch c {
+	case LinearCurve:
+		return "LinearCurve"
+	case ExponentialCurve:
+		return "ExponentialCurve"
+	case EqualPowerCurve:
+		return "EqualPowerCurve"
+	default:
+		return fmt.Sprintf("Curve(%d)", int(c))
+	}
+}
+
+// silence is the volume that exponential fades treat as silent, -60 dB.
+const silence = 0.001
+
+// at returns the value between from and to at t in the range [0..1).
+func (c Curve) at(from, to float32, t float64) float32 {
+	switch c {
+	case ExponentialCurve:
+		a := math.Max(float64(from), silence)
+		b := math.Max(float64(to), silence)
+		return float32(a * math.Pow(b/a, t))
+	case EqualPowerCurve:
+		if to > from {
+			return from + (to-from)*float32(math.Sin(t*math.Pi/2))
+		}
+		return to + (from-to)*float32(math.Cos(t*math.Pi/2))
+	default:
+		return from + (to-from)*float32(t)
+	}
+}
+
+func (s *sound) FadeTo(v float32, d time.Duration, curve Curve) {
+	s.change(fadeChange(v, d, curve, false))
+}
+
+func (s *sound) FadeOutAndStop(d time.Duration) {
+	s.change(fadeOutAndStopChange(d))
+}
+
+func fadeOutAndStopChange(d time.Duration) change {
+	return fadeChange(0, d, ExponentialCurve, true)
+}
+
+func fadeChange(volume float32, d time.Duration, curve Curve, stop bool) change {
+	return change{
+		apply: func(s *sound) { s.fadeTo(volume, d, curve, stop) },
+		expect: func(_ *sound, v *soundView) {
+			v.volume = clampVolume(volume)
+			v.fading = true
+		},
+	}
+}
+
+func (s *sound) fadeTo(v float32, d time.Duration, curve Curve, stop bool) {
+	v = clampVolume(v)
+	s.volume = v
+	s.fading = true
+	s.stopAfterFade = stop
+	s.gain.setCurve(v, durationToFrames(d, s.mixer.format.SamplesPerSecond), curve)
+}
+
+func (s *sound) Fading() bool {
+	return s.view().fading
+}
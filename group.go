@@ -0,0 +1,354 @@
+package mixer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Group is a bus that sounds are mixed through, e.g. for the music, the sound
+// effects or the voices of a game. Groups can be nested. The volume, pan,
+// mute, solo and pause of a group apply to all sounds in it and in its
+// subgroups. Changes to the volume, pan, mute and solo are ramped over the
+// smoothing time set in the mixer's Options.
+//
+// Assign sounds to a group with Sound.SetGroup or SoundSource.SetGroup.
+type Group interface {
+	// Name returns the name that the group was created with.
+	Name() string
+
+	// Parent returns the group that this group is nested in, it is nil for
+	// top-level groups.
+	Parent() Group
+
+	// SetVolume sets the volume factor of the group. The volumes of the
+	// sounds in the group are multiplied with it and with the volumes of the
+	// parent groups. Its range is [0..1] and it will be clamped to that
+	// range.
+	SetVolume(float32)
+	Volume() float32
+
+	// SetPan changes the volume ratio between left and right output channel
+	// for all sounds in the group, see Sound.SetPan. It is combined with the
+	// pans of the sounds and the parent groups.
+	SetPan(float32)
+	Pan() float32
+
+	// SetMuted silences or un-silences all sounds in the group. The sounds
+	// keep playing while the group is muted.
+	SetMuted(bool)
+	Muted() bool
+
+	// SetSolo soloes the group. While any group of the mixer is soloed, only
+	// the sounds in soloed groups and their subgroups are heard, all other
+	// sounds are silenced, including sounds without a group.
+	SetSolo(bool)
+	Solo() bool
+
+	// SetPaused pauses or resumes all sounds in the group, e.g. when the game
+	// shows its pause menu. The sounds do not advance while their group is
+	// paused. This does not change the value of Sound.Paused, when the group
+	// is resumed, the sounds that were not paused themselves continue to
+	// play.
+	SetPaused(bool)
+	Paused() bool
+}
+
+// NewGroup creates a group with the given name. If parent is not nil, the new
+// group is nested in it. The name must be unique among the mixer's groups.
+func (m *Mixer) NewGroup(name string, parent Group) (Group, error) {
+	if name == "" {
+		return nil, errors.New("mixer.NewGroup: name is empty")
+	}
+	p, ok := m.ownGroup(parent)
+	if !ok {
+		return nil, errors.New("mixer.NewGroup: parent belongs to another mixer")
+	}
+
+	m.groupLock.Lock()
+	defer m.groupLock.Unlock()
+
+	if m.groupNames[name] != nil {
+		return nil, fmt.Errorf("mixer.NewGroup: group %q exists already", name)
+	}
+	g := newGroup(m, name, p)
+	if m.groupNames == nil {
+		m.groupNames = make(map[string]*group)
+	}
+	m.groupNames[name] = g
+	m.post(func() {
+		m.groups = append(m.groups, g)
+		m.updateSolo()
+	})
+	return g, nil
+}
+
+// Group returns the group with the given name, nil if there is none.
+func (m *Mixer) Group(name string) Group {
+	m.groupLock.Lock()
+	defer m.groupLock.Unlock()
+
+	if g := m.groupNames[name]; g != nil {
+		return g
+	}
+	return nil
+}
+
+// ownGroup returns g as a group of m. If g is nil, it returns nil, ok is
+// false if g belongs to another mixer.
+func (m *Mixer) ownGroup(g Group) (own *group, ok bool) {
+	if g == nil {
+		return nil, true
+	}
+	own, ok = g.(*group)
+	return own, ok && own.mixer == m
+}
+
+type group struct {
+	mixer  *Mixer
+	name   string
+	parent *group
+
+	// settings are what the getters return, they are changed under lock
+	// right away; the mixer applies them with the next mix
+	settings groupSettings
+	lock     sync.Mutex
+
+	// the following fields are only used by the mixer
+
+	groupSettings
+	// gain ramps to the volume, or 0 if the group is muted; leftPan and
+	// rightPan ramp to the pan factors
+	gain, leftPan, rightPan ramp
+	// solo ramps to 0 if the sounds of the group are silenced because other
+	// groups are soloed, heard is its target
+	solo  ramp
+	heard bool
+	// left and right are the factors of the group and all its parents for
+	// each frame of the current mix; the sounds of the group are multiplied
+	// with soundLeft and soundRight, which include the solo
+	left, right           []float32
+	soundLeft, soundRight []float32
+}
+
+type groupSettings struct {
+	volume, pan           float32
+	muted, soloed, paused bool
+}
+
+func newGroup(m *Mixer, name string, parent *group) *group {
+	settings := groupSettings{volume: 1}
+	return &group{
+		mixer:         m,
+		name:          name,
+		parent:        parent,
+		settings:      settings,
+		groupSettings: settings,
+		gain:          constantRamp(1),
+		leftPan:       constantRamp(1),
+		rightPan:      constantRamp(1),
+		solo:          constantRamp(1),
+		heard:         true,
+	}
+}
+
+func (g *group) Name() string {
+	return g.name
+}
+
+func (g *group) Parent() Group {
+	if g.parent == nil {
+		return nil
+	}
+	return g.parent
+}
+
+// change changes the settings with f right away and posts the change to the
+// mixer.
+func (g *group) change(f func(s *groupSettings)) {
+	g.lock.Lock()
+	f(&g.settings)
+	g.lock.Unlock()
+
+	g.mixer.post(func() {
+		f(&g.groupSettings)
+		g.update()
+	})
+}
+
+// current returns the settings that the getters return.
+func (g *group) current() groupSettings {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.settings
+}
+
+func (g *group) SetVolume(v float32) {
+	v = clampVolume(v)
+	g.change(func(s *groupSettings) { s.volume = v })
+}
+
+func (g *group) Volume() float32 {
+	return g.current().volume
+}
+
+func (g *group) SetPan(p float32) {
+	p = clampPan(p)
+	g.change(func(s *groupSettings) { s.pan = p })
+}
+
+func (g *group) Pan() float32 {
+	return g.current().pan
+}
+
+func (g *group) SetMuted(muted bool) {
+	g.change(func(s *groupSettings) { s.muted = muted })
+}
+
+func (g *group) Muted() bool {
+	return g.current().muted
+}
+
+func (g *group) SetSolo(solo bool) {
+	g.change(func(s *groupSettings) { s.soloed = solo })
+}
+
+func (g *group) Solo() bool {
+	return g.current().soloed
+}
+
+func (g *group) SetPaused(paused bool) {
+	g.change(func(s *groupSettings) { s.paused = paused })
+}
+
+func (g *group) Paused() bool {
+	return g.current().paused
+}
+
+// pausedSetting returns true if the group or one of its parents is set to be
+// paused.
+func (g *group) pausedSetting() bool {
+	for ; g != nil; g = g.parent {
+		if g.Paused() {
+			return true
+		}
+	}
+	return false
+}
+
+// update ramps the group to its settings. It must be called with the mixer
+// locked.
+func (g *group) update() {
+	frames := g.mixer.groupRampFrames()
+	gain := g.volume
+	if g.muted {
+		gain = 0
+	}
+	left, right := panFactors(g.pan)
+	g.gain.set(gain, frames)
+	g.leftPan.set(left, frames)
+	g.rightPan.set(right, frames)
+	g.mixer.updateSolo()
+}
+
+// isPaused returns true if the group or one of its parents is paused. It must
+// be called with the mixer locked.
+func (g *group) isPaused() bool {
+	for ; g != nil; g = g.parent {
+		if g.paused {
+			return true
+		}
+	}
+	return false
+}
+
+// isSoloed returns true if the group or one of its parents is soloed. It must
+// be called with the mixer locked.
+func (g *group) isSoloed() bool {
+	for ; g != nil; g = g.parent {
+		if g.soloed {
+			return true
+		}
+	}
+	return false
+}
+
+// shift moves the group's changes by the given number of frames.
+func (g *group) shift(frames int64) {
+	g.gain.shift(frames)
+	g.leftPan.shift(frames)
+	g.rightPan.shift(frames)
+	g.solo.shift(frames)
+}
+
+// groupRampFrames returns the number of frames over which changes to groups
+// are ramped. If the mixer is not running, they are applied right away.
+func (m *Mixer) groupRampFrames() int {
+	if m.state == Running {
+		return m.smoothingFrames
+	}
+	return 0
+}
+
+// updateSolo silences the groups that are not heard because other groups are
+// soloed. It must be called with the mixer locked.
+func (m *Mixer) updateSolo() {
+	anySolo := false
+	for _, g := range m.groups {
+		if g.soloed {
+			anySolo = true
+		}
+	}
+	m.ungrouped.setHeard(!anySolo)
+	for _, g := range m.groups {
+		g.setHeard(!anySolo || g.isSoloed())
+	}
+}
+
+func (g *group) setHeard(heard bool) {
+	if heard == g.heard {
+		return
+	}
+	g.heard = heard
+	var gain float32
+	if heard {
+		gain = 1
+	}
+	g.solo.set(gain, g.mixer.groupRampFrames())
+}
+
+// mixGroups computes the factors of all groups for mixing frameCount output
+// frames, starting at the given frame. The factors of a parent group are
+// computed before those of its subgroups since the groups are created in
+// this order.
+func (m *Mixer) mixGroups(frame int64, frameCount int) {
+	m.ungrouped.startMix(frame, frameCount)
+	for _, g := range m.groups {
+		g.startMix(frame, frameCount)
+	}
+}
+
+func (g *group) startMix(frame int64, frameCount int) {
+	if len(g.left) < frameCount {
+		g.left = make([]float32, frameCount)
+		g.right = make([]float32, frameCount)
+		g.soundLeft = make([]float32, frameCount)
+		g.soundRight = make([]float32, frameCount)
+	}
+	g.gain.start(frame)
+	g.leftPan.start(frame)
+	g.rightPan.start(frame)
+	g.solo.start(frame)
+	for i := 0; i < frameCount; i++ {
+		f := frame + int64(i)
+		gain := g.gain.at(f)
+		left, right := gain*g.leftPan.at(f), gain*g.rightPan.at(f)
+		if g.parent != nil {
+			left *= g.parent.left[i]
+			right *= g.parent.right[i]
+		}
+		g.left[i], g.right[i] = left, right
+		solo := g.solo.at(f)
+		g.soundLeft[i], g.soundRight[i] = left*solo, right*solo
+	}
+}
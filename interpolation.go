@@ -0,0 +1,123 @@
+package mixer
+
+import (
+	"fmt"
+	"math"
+)
+
+// Interpolation is the method used to compute sound samples between the
+// samples of the source data. This is necessary when the source's sample
+// rate differs from the output sample rate or when a sound is played with a
+// pitch other than 1. Better quality costs more CPU time.
+type Interpolation int
+
+const (
+	// Linear interpolates linearly between the two neighbouring samples.
+	Linear Interpolation = iota
+	// Nearest uses the closest sample. It is the fastest method but adds
+	// audible distortion.
+	Nearest
+	// Cubic uses a Catmull-Rom spline through the four neighbouring samples.
+	Cubic
+	// Sinc uses a windowed sinc filter (Lanczos) over the eight neighbouring
+	// samples. It has the best quality and is the slowest method.
+	Sinc
+)
+
+func (i Interpolation) String() string {
+	switch i {
+	case Linear:
+		return "Linear"
+	case Nearest:
+		return "Nearest"
+	case Cubic:
+		return "Cubic"
+	case Sinc:
+		return "Sinc"
+	default:
+		return fmt.Sprintf("Interpolation(%d)", int(i))
+	}
+}
+
+// sincTaps is half the number of samples that the Sinc interpolation uses.
+const sincTaps = 4
+
+// sampleAt returns the interpolated source sample at the fractional position
+// pos in the given loop.
+func (s *sound) sampleAt(pos float64, loop int) (left, right float32) {
+	i := int(pos)
+	f := pos - float64(i)
+	if f == 0 {
+		return s.neighbour(i, 0, loop)
+	}
+
+	switch s.mixer.interpolation {
+	case Nearest:
+		if f < 0.5 {
+			return s.neighbour(i, 0, loop)
+		}
+		return s.neighbour(i, 1, loop)
+	case Cubic:
+		l0, r0 := s.neighbour(i, -1, loop)
+		l1, r1 := s.neighbour(i, 0, loop)
+		l2, r2 := s.neighbour(i, 1, loop)
+		l3, r3 := s.neighbour(i, 2, loop)
+		t := float32(f)
+		return catmullRom(l0, l1, l2, l3, t), catmullRom(r0, r1, r2, r3, t)
+	case Sinc:
+		var sum float32
+		for k := -sincTaps + 1; k <= sincTaps; k++ {
+			l, r := s.neighbour(i, k, loop)
+			w := float32(lanczos(f - float64(k)))
+			left += l * w
+			right += r * w
+			sum += w
+		}
+		// the weights do not add up to exactly 1, normalize them so that
+		// constant signals stay constant
+		return left / sum, right / sum
+	default:
+		left, right = s.neighbour(i, 0, loop)
+		nextL, nextR := s.neighbour(i, 1, loop)
+		t := float32(f)
+		return left + (nextL-left)*t, right + (nextR-right)*t
+	}
+}
+
+// neighbour returns the source sample that is offset samples away from the
+// sample i in the given loop, following the loop seams. Outside the sound
+// data, it returns the first or last sample.
+func (s *sound) neighbour(i, offset, loop int) (left, right float32) {
+	i += offset
+	for i >= s.loopEnd && s.wraps(loop) {
+		i -= s.loopLength()
+		loop++
+	}
+	for i < s.loopStart && loop > 0 {
+		i += s.loopLength()
+		loop--
+	}
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(s.source.left) {
+		i = len(s.source.left) - 1
+	}
+	return s.frame(i, loop)
+}
+
+func catmullRom(p0, p1, p2, p3, t float32) float32 {
+	return p1 + 0.5*t*(p2-p0+t*(2*p0-5*p1+4*p2-p3+t*(3*(p1-p2)+p3-p0)))
+}
+
+// lanczos is the windowed sinc kernel for Sinc interpolation.
+func lanczos(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	if x <= -sincTaps || x >= sincTaps {
+		return 0
+	}
+	px := math.Pi * x
+	return sincTaps * math.Sin(px) * math.Sin(px/sincTaps) / (px * px)
+}
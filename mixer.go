@@ -1,7 +1,10 @@
 // Package mixer provides an abstraction over the sound card to be able to play
 // multiple sounds simultaneously, combining different effects.
 //
-// Call Init to start the mixer and Close when you are done with it.
+// Call Init to start the mixer and Close when you are done with it. The mixed
+// sound is output through a Backend, by default DirectSound on Windows. The
+// package level functions use a default Mixer, call New to create independent
+// Mixers.
 // Call NewSoundSource to create a sound source from PCM data. You can use this
 // source to play the sound using the Play... functions. Each call will give you
 // a Sound which represents that particular instance of the sound source that
@@ -10,24 +13,72 @@
 // return true. In this case you cannot use the Sound anymore and should set its
 // pointer to nil so the Go garbage collector can remove it. Calling any
 // function on a Stopped Sound has no effect.
+//
+// Sounds can be mixed through Groups, e.g. to have separate volumes for music
+// and sound effects, see Mixer.NewGroup.
+//
+// The functions of Sounds, SoundSources and Groups, as well as Mixer.SetVolume,
+// Mixer.Batch, Mixer.Now and Mixer.Clock never wait for the mixer to finish
+// mixing, so it is safe to call them in a game loop. Changes are queued and
+// applied before the next data is mixed, the getters return them right away.
 package mixer
 
 import (
+	"encoding/binary"
+	"errors"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/gonutz/mixer/dsound"
+	"unsafe"
 )
 
-// TODO right now the volume and pan only change in discrete chunks, whenever
-// the sound buffer is written. To be accurate, individual samples would have
-// to be modified depeding on when they are played, this can get hairy to
-// implement so think about if it makes sense to do that or if the current
-// solution is good enough
-// TODO have SetPitch in Sound? Or In SoundSource?
-
-var (
-	// writeCursor keeps the offset into DirectSound's ring buffer at which data
+// Mixer mixes all its playing sounds and outputs them through a Backend. Each
+// Mixer has its own sounds, settings and Go routine so you can use several
+// independent mixers at the same time, e.g. one per output device.
+//
+// The package level functions use a default Mixer.
+type Mixer struct {
+	// lastNow is the last value returned by Now, it keeps the clock
+	// monotonic; it is accessed atomically and comes first to be 64-bit
+	// aligned on 32-bit platforms
+	lastNow int64
+
+	// backend is the output device that the mixed sound is written to
+	backend Backend
+
+	// format is the output format of the mixer, frameSize is the number of
+	// bytes that one sample of all channels takes in this format
+	format    Format
+	frameSize int
+
+	// updateInterval is the longest time that the mixer's Go routine waits
+	// before it applies posted changes
+	updateInterval time.Duration
+
+	// bufferSize is the requested size of the backend's ring buffer in bytes
+	bufferSize uint
+
+	// recovery is the policy for backend errors, nil means no recovery
+	recovery *Recovery
+
+	// backendClosed is set if the backend was closed during recovery so Close
+	// must not close it again
+	backendClosed bool
+
+	// deviceLatency is the number of bytes between the backend's play and
+	// write cursors at the last update
+	deviceLatency uint
+
+	// frames is the number of output frames that were advanced since Init,
+	// this is the output frame at the write cursor of the last update
+	frames int64
+	// realTime is true if the mixer's Go routine updates it, lastUpdate is
+	// the time of the last update then; the clock is extrapolated from it
+	realTime   bool
+	lastUpdate time.Time
+
+	// writeCursor keeps the offset into the backend's ring buffer at which data
 	// was written last
 	writeCursor uint
 
@@ -38,14 +89,39 @@ var (
 	// lock is for changes to the mixer state and changes to the sound, these
 	// must not occur while mixing sound data
 	lock sync.Mutex
-
-	// volume is in the range from 0 (silent) to 1 (full volume)
-	volume float32
-
-	// stop is a signalling channel for the mixer to know when to stop updating
-	// (which happens in a separate Go routine), e.g. after Close was called or
-	// when an error happened from which it cannot recover
+	// commands are the changes that the API functions post to the mixer so
+	// they do not wait for the lock while mixing; mixing is 1 while the mixer
+	// is Running and applies the commands itself, it is accessed atomically
+	commands commandQueue
+	mixing   int32
+	// changed receives a value when a command is posted while the mixer is
+	// Running, it wakes up the update Go routine to apply it
+	changed chan struct{}
+	// clockView is the *mixerView that the getters read, see view
+	clockView unsafe.Pointer
+
+	// volume is the master volume, it ramps to values in the range from 0
+	// (silent) to 1 (full volume)
+	volume ramp
+
+	// groups are all groups of the mixer, parents before their subgroups;
+	// sounds without a group are mixed through ungrouped, which is not in
+	// groups; groupNames are the groups by name, they are changed with
+	// groupLock held and are not used by the mixer
+	groups     []*group
+	ungrouped  *group
+	groupNames map[string]*group
+	groupLock  sync.Mutex
+
+	// smoothingFrames is the number of frames over which parameter changes
+	// are ramped
+	smoothingFrames int
+
+	// stop is closed to signal the mixer's update Go routine to stop, e.g.
+	// after Close was called; the Go routine closes done when it exits, either
+	// because of stop or because of an error it cannot recover from
 	stop chan bool
+	done chan bool
 
 	// writeAheadBuffer and mixBuffer are the buffers for mixing the sound
 	// sources; their size determines the time of the sound that will be output
@@ -60,98 +136,303 @@ var (
 	// queried by the client using the Error function
 	lastError error
 
-	// inited is used to coordinate multiple and/or concurrent calls to Init
-	// and Close
-	inited   bool
+	// stats are updated in every update, they are reset in Init
+	stats stats
+
+	// sched measures the backend's consumption rate to time the updates
+	sched scheduler
+
+	// recorder writes all output data to a WAV file if it is not nil
+	recorder *recorder
+
+	// offline is true if the mixer was initialized with InitOffline, in this
+	// case there is no backend and no Go routine
+	offline bool
+
+	// closePolicy determines what happens to the sounds in Close
+	closePolicy ClosePolicy
+
+	// interpolation is used for reading sounds at fractional positions
+	interpolation Interpolation
+
+	// state is the lifecycle state, it is read under lock; initLock is used to
+	// coordinate multiple and/or concurrent calls to Init and Close
+	state    State
 	initLock sync.Mutex
-)
+}
 
-const (
-	bytesPerSample = 4                      // 2 channels, 16 bit each
-	bytesPerSecond = 44100 * bytesPerSample // fixed sample frequency of 44100Hz
-)
+// New creates a Mixer at full volume. Call Init or InitOffline on it to start
+// mixing. You can create SoundSources for the Mixer and play them before
+// calling Init, they are output once the Mixer is running.
+func New() *Mixer {
+	m := &Mixer{
+		volume:  constantRamp(1),
+		changed: make(chan struct{}, 1),
+		sched:   newScheduler(),
+	}
+	m.ungrouped = newGroup(m, "", nil)
+	return m
+}
 
-// Init sets up DirectSound and prepares for mixing and playing sounds. It
-// starts a Go routine that periodically writes to the sound buffer to output
-// to the sound card.
+// Init opens the given Backend and prepares for mixing and playing sounds. It
+// starts a Go routine that periodically writes to the backend's sound buffer to
+// output to the sound card. If b is nil, the default backend for the platform
+// is used, which is DirectSound on Windows. Other platforms have no default
+// backend.
+// The output format and timing is configured with the given Options, pass nil
+// to use the defaults of 44100 Hz, 2 channels, 16 bit samples and 100ms of
+// write-ahead, updated every 10ms.
 // Call Close when you are done with the mixer.
-func Init() error {
-	initLock.Lock()
-	defer initLock.Unlock()
-	if inited {
-		return nil
+func (m *Mixer) Init(b Backend, opts *Options) error {
+	m.initLock.Lock()
+	defer m.initLock.Unlock()
+	if done, err := m.checkInit(); done {
+		return err
 	}
 
-	if err := dsound.Init(44100); err != nil {
+	c, err := opts.config()
+	if err != nil {
 		return err
 	}
 
-	writeAheadByteCount := bytesPerSecond / 10 // buffer 100ms
-	// make sure it is evenly dividable into samples
-	writeAheadByteCount -= writeAheadByteCount % bytesPerSample
-	writeAheadBuffer = make([]byte, writeAheadByteCount)
-	mixBuffer = make([]float32, writeAheadByteCount/2) // 2 bytes form one value
-	leftBuffer = mixBuffer[:len(mixBuffer)/2]
-	rightBuffer = mixBuffer[len(mixBuffer)/2:]
-	volume = 1
+	if b == nil {
+		b, err = defaultBackend()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := b.Open(c.format, c.bufferSize()); err != nil {
+		return err
+	}
+	m.backend = b
+	m.backendClosed = false
+	m.bufferSize = c.bufferSize()
+	m.recovery = c.recovery
+	m.setup(c)
+	if b.BufferSize() < uint(2*len(m.writeAheadBuffer)) {
+		b.Close()
+		return errors.New("mixer.Init: backend buffer is smaller than twice the write-ahead")
+	}
 
 	// initially write silence to sound buffer
-	if err := dsound.WriteToSoundBuffer(writeAheadBuffer, 0); err != nil {
+	if err := b.Write(m.writeAheadBuffer, 0); err != nil {
+		b.Close()
 		return err
 	}
-	if err := dsound.StartSound(); err != nil {
+	if err := b.Start(); err != nil {
+		b.Close()
 		return err
 	}
 
-	stop = make(chan bool)
-	go func() {
-		pulse := time.Tick(10 * time.Millisecond)
-		for {
-			select {
-			case <-pulse:
-				update()
-				if lastError != nil {
-					return
-				}
-			case <-stop:
+	if manual, ok := b.(manualBackend); ok {
+		// the backend drives the updates itself
+		m.stop = nil
+		m.done = nil
+		manual.setUpdate(m.manualUpdate, m.updateInterval)
+		m.setState(Running)
+		return nil
+	}
+
+	m.lock.Lock()
+	m.realTime = true
+	m.lastUpdate = time.Now()
+	m.lock.Unlock()
+
+	m.stop = make(chan bool)
+	m.done = make(chan bool)
+	go m.run(m.stop, m.done)
+
+	m.setState(Running)
+
+	return nil
+}
+
+// checkInit returns done == true if Init must not continue, either because
+// the mixer is already running or because it failed and was not closed.
+func (m *Mixer) checkInit() (done bool, err error) {
+	switch m.State() {
+	case Running:
+		return true, nil
+	case Failed:
+		return true, errors.New(
+			"mixer.Init: mixer has failed, call Close before initializing it again")
+	}
+	return false, nil
+}
+
+// manualUpdate is the update function for manual backends.
+func (m *Mixer) manualUpdate() bool {
+	if !m.update() {
+		m.fail()
+		return false
+	}
+	return true
+}
+
+// run is the mixer's update Go routine. It updates the mixer whenever the
+// backend is expected to need new data or notifies that it does, and within
+// the update interval after changes were posted, until stop is closed or an
+// error occurs that cannot be recovered from. It closes done when it exits.
+func (m *Mixer) run(stop, done chan bool) {
+	defer close(done)
+
+	var notify <-chan struct{}
+	if n, ok := m.backend.(Notifier); ok {
+		notify = n.Notify()
+	}
+
+	sleep := m.nextWakeup()
+	wakeup := time.Now().Add(sleep)
+	timer := time.NewTimer(sleep)
+	defer timer.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-timer.C:
+		case <-notify:
+			stopTimer(timer)
+		case <-m.changed:
+			// changes that are posted in quick succession are applied in a
+			// single update
+			if soon := time.Now().Add(m.updateInterval); soon.Before(wakeup) {
+				stopTimer(timer)
+				timer.Reset(m.updateInterval)
+				wakeup = soon
+			}
+			continue
+		case <-stop:
+			return
+		}
+
+		if m.update() {
+			failures = 0
+		} else {
+			failures++
+			if !m.recover(stop, failures) {
+				m.fail()
 				return
-			default:
-				time.Sleep(1 * time.Millisecond)
 			}
 		}
-	}()
+		sleep = m.nextWakeup()
+		wakeup = time.Now().Add(sleep)
+		timer.Reset(sleep)
+	}
+}
 
-	inited = true
+// stopTimer stops t and drains its channel, so it can be reset.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
 
-	return nil
+// setup applies the configuration and resets the mixer for a fresh start.
+func (m *Mixer) setup(c config) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.writeCursor = 0
+	m.deviceLatency = 0
+	// the clock starts over, the sounds that were kept continue their changes
+	// from where they were
+	m.applyCommands()
+	m.shiftClock(-m.frames)
+	m.frames = 0
+	m.realTime = false
+	m.lastUpdate = time.Time{}
+	m.stats = stats{}
+	m.sched.reset()
+	m.lastError = nil
+	m.closePolicy = c.closePolicy
+	m.interpolation = c.interpolation
+	m.smoothingFrames = c.smoothingFrames()
+	m.format = c.format
+	m.frameSize = c.format.FrameSize()
+	m.updateInterval = c.updateInterval
+	writeAheadFrameCount := c.writeAheadFrames()
+	m.writeAheadBuffer = make([]byte, writeAheadFrameCount*m.frameSize)
+	// Init writes the first write-ahead right away
+	m.sched.wrote(len(m.writeAheadBuffer))
+	// the sounds are always mixed in stereo
+	m.mixBuffer = make([]float32, writeAheadFrameCount*2)
+	m.leftBuffer = m.mixBuffer[:len(m.mixBuffer)/2]
+	m.rightBuffer = m.mixBuffer[len(m.mixBuffer)/2:]
+	m.publish()
+	atomic.StoreInt64(&m.lastNow, 0)
 }
 
-// Close blocks until playing sound is stopped. It shuts down the DirectSound
-// system.
-func Close() {
-	initLock.Lock()
-	defer initLock.Unlock()
-	if !inited {
+// Close blocks until playing sound is stopped. It stops and closes the
+// backend. Close also ends offline rendering and stops recording. It always
+// returns, even if the mixer has Failed. Depending on the ClosePolicy in the
+// Options, the sounds are stopped or kept for the next Init.
+func (m *Mixer) Close() {
+	m.initLock.Lock()
+	defer m.initLock.Unlock()
+	if m.State() == Stopped {
 		return
 	}
+	m.setState(Closing)
+
+	m.StopRecording()
+
+	m.lock.Lock()
+	offline := m.offline
+	m.offline = false
+	m.lock.Unlock()
+
+	if !offline {
+		if m.stop != nil {
+			close(m.stop)
+			<-m.done
+		}
+		if !m.backendClosed {
+			// with the lock held, a manual backend cannot be in an update
+			m.lock.Lock()
+			m.backend.Stop()
+			m.backend.Close()
+			m.lock.Unlock()
+		}
+	}
 
-	stop <- true
-	dsound.StopSound()
-	dsound.Close()
+	if m.closePolicy == ReleaseSounds {
+		m.releaseSounds()
+	}
+
+	m.setState(Stopped)
+}
 
-	inited = false
+// Latency returns the effective output latency. This is the maximum time it
+// takes for a change, e.g. starting or pausing a sound, to become audible. It
+// consists of the update interval and the latency of the backend, as measured
+// by the distance between its play and write cursors in the last update.
+// In offline mode, the latency is 0.
+func (m *Mixer) Latency() time.Duration {
+	clock := m.view()
+	if clock == nil || clock.offline {
+		return 0
+	}
+	return clock.updateInterval +
+		samplesToDuration(clock.deviceLatency, clock.samplesPerSecond)
 }
 
 // Error returns the last error that occurred. If a fatal error occurs, the Go
 // routine for mixing and playing sounds might stop before you call Close. In
-// this case, call Error to retrieve the cause of the failure.
-func Error() error {
-	return lastError
+// this case, the State is Failed and Error returns the cause of the failure.
+func (m *Mixer) Error() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.lastError
 }
 
 // SetVolume sets the master volume. All sounds will be scaled by this factor.
-// It is in the range [0..1] and will be clamped to it.
-func SetVolume(v float32) {
+// It is in the range [0..1] and will be clamped to it. While the mixer is
+// running, the change is ramped over the smoothing time set in the Options.
+func (m *Mixer) SetVolume(v float32) {
 	if v < 0 {
 		v = 0
 	}
@@ -159,57 +440,151 @@ func SetVolume(v float32) {
 		v = 1
 	}
 
-	lock.Lock()
-	defer lock.Unlock()
-
-	volume = v
+	m.post(func() {
+		if m.state == Running {
+			m.volume.set(v, m.smoothingFrames)
+		} else {
+			m.volume = constantRamp(v)
+		}
+	})
 }
 
-func update() {
-	lock.Lock()
-	defer lock.Unlock()
+// update advances the sounds by the time that was played since the last
+// update and writes newly mixed data to the backend. It returns false if an
+// error occurred, in this case the mixer cannot continue. If the mixer is not
+// Running, update does nothing.
+func (m *Mixer) update() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.state != Running {
+		// e.g. Close is shutting the mixer down and closed the backend
+		return true
+	}
+	m.stats.Wakeups++
+	// apply the changes before advancing, they were made while the data
+	// before the write cursor was played
+	changed := m.applyCommands()
 
-	_, write, err := dsound.GetPlayAndWriteCursors()
+	play, write, err := m.backend.Positions()
 	if err != nil {
-		lastError = err
-		return
+		m.lastError = err
+		return false
+	}
+	if write >= play {
+		m.deviceLatency = write - play
+	} else {
+		m.deviceLatency = write + m.backend.BufferSize() - play
+	}
+	if m.realTime {
+		m.lastUpdate = time.Now()
 	}
-	if write != writeCursor {
+	if write != m.writeCursor {
 		var delta uint
-		if write > writeCursor {
-			delta = write - writeCursor
+		if write > m.writeCursor {
+			delta = write - m.writeCursor
 		} else {
-			// wrap-around happened in DirectSound's ring buffer
-			delta = write + dsound.BufferSize() - writeCursor
+			// wrap-around happened in the backend's ring buffer
+			delta = write + m.backend.BufferSize() - m.writeCursor
 		}
-		advanceSoundsByBytes(int(delta))
+		// the start of the previously mixed data was played since the last
+		// update; if more than that was played, the device played whatever
+		// was left in its buffer, this is recorded as silence
+		played := int(delta)
+		if played > len(m.writeAheadBuffer) {
+			played = len(m.writeAheadBuffer)
+		}
+		m.record(m.writeAheadBuffer[:played])
+		m.recordSilence(int(delta) - played)
+		m.countUpdate(int(delta), m.sched.measure(int(delta)))
 
+		m.advanceByFrames(int(delta) / m.frameSize)
+		changed = true
+	}
+	if changed {
 		// rewrite the whole look-ahead with newly mixed data
-		lastError = dsound.WriteToSoundBuffer(mix(), write)
-		if lastError != nil {
-			return
+		m.lastError = m.backend.Write(m.mix(), write)
+		if m.lastError != nil {
+			return false
 		}
+		m.sched.wrote(len(m.writeAheadBuffer))
 	}
-	writeCursor = write
+	m.writeCursor = write
+	m.publish()
+	return true
 }
 
-func mix() []byte {
-	for i := range mixBuffer {
-		mixBuffer[i] = 0.0
+// mix mixes a whole write-ahead buffer of sound data in the output format.
+func (m *Mixer) mix() []byte {
+	return m.encode(m.mixFrames(len(m.leftBuffer)))
+}
+
+// encode converts the given left and right channel data to the output format
+// and returns it. The returned data is valid until the next call to encode.
+func (m *Mixer) encode(leftBuffer, rightBuffer []float32) []byte {
+	out := m.writeAheadBuffer
+	for i := range leftBuffer {
+		left, right := leftBuffer[i], rightBuffer[i]
+		if m.format.ChannelCount == 1 {
+			out = encodeSample(out, m.format.SampleFormat, (left+right)/2)
+		} else {
+			out = encodeSample(out, m.format.SampleFormat, left)
+			out = encodeSample(out, m.format.SampleFormat, right)
+		}
 	}
 
-	for _, sound := range sounds {
-		sound.addToMixBuffer()
+	return m.writeAheadBuffer[:len(leftBuffer)*m.frameSize]
+}
+
+// mixFrames mixes the next frameCount samples of all sounds, scaled by the
+// master volume. The returned buffers for the left and right channel are
+// valid until the next call to mixFrames. frameCount must not be greater than
+// the length of the write-ahead buffer.
+func (m *Mixer) mixFrames(frameCount int) (left, right []float32) {
+	left, right = m.leftBuffer[:frameCount], m.rightBuffer[:frameCount]
+	for i := range left {
+		left[i] = 0.0
+		right[i] = 0.0
 	}
 
-	out := 0
-	for i := range leftBuffer {
-		writeAheadBuffer[out], writeAheadBuffer[out+1] = floatToBytes(leftBuffer[i] * volume)
-		writeAheadBuffer[out+2], writeAheadBuffer[out+3] = floatToBytes(rightBuffer[i] * volume)
-		out += 4
+	// the mix starts at the output frame of the write cursor
+	frame := m.frames
+	m.mixGroups(frame, frameCount)
+	for _, sound := range m.sounds {
+		sound.startRamps(frame)
+		sound.addToMixBuffer(left, right, frame)
+	}
+
+	m.volume.start(frame)
+	for i := range left {
+		v := m.volume.at(frame + int64(i))
+		left[i] *= v
+		right[i] *= v
 	}
 
-	return writeAheadBuffer
+	return left, right
+}
+
+// encodeSample writes f in the given sample format to the start of buf and
+// returns the rest of buf.
+func encodeSample(buf []byte, format SampleFormat, f float32) []byte {
+	switch format {
+	case Int24:
+		buf[0], buf[1], buf[2] = floatTo24BitBytes(f)
+		return buf[3:]
+	case Float32:
+		if f < -1 {
+			f = -1
+		}
+		if f > 1 {
+			f = 1
+		}
+		binary.LittleEndian.PutUint32(buf, math.Float32bits(f))
+		return buf[4:]
+	default:
+		buf[0], buf[1] = floatToBytes(f)
+		return buf[2:]
+	}
 }
 
 func floatToBytes(f float32) (lo, hi byte) {
@@ -229,15 +604,62 @@ func floatToBytes(f float32) (lo, hi byte) {
 
 }
 
-func advanceSoundsByBytes(byteCount int) {
-	for i := 0; i < len(sounds); i++ {
-		if !sounds[i].paused {
-			sounds[i].advanceBySamples(byteCount / 4)
-			if sounds[i].isOver() {
-				sounds[i].source = nil
-				sounds = append(sounds[:i], sounds[i+1:]...)
-				i--
-			}
+func floatTo24BitBytes(f float32) (lo, mid, hi byte) {
+	var value int32
+	if f < 0 {
+		if f < -1 {
+			f = -1
+		}
+		value = int32(float64(f) * 8388608)
+	} else {
+		if f > 1 {
+			f = 1
+		}
+		value = int32(float64(f) * 8388607)
+	}
+	return byte(value & 0xFF), byte((value >> 8) & 0xFF), byte((value >> 16) & 0xFF)
+}
+
+// shiftClock moves everything that is scheduled at frames of the mixer clock
+// by the given number of frames.
+func (m *Mixer) shiftClock(frames int64) {
+	m.volume.shift(frames)
+	m.ungrouped.shift(frames)
+	for _, g := range m.groups {
+		g.shift(frames)
+	}
+	for _, s := range m.sounds {
+		s.gain.shift(frames)
+		s.leftPan.shift(frames)
+		s.rightPan.shift(frames)
+		s.envelope.shift(frames)
+		s.pauseAt += frames
+	}
+}
+
+// removeSound stops s right away and removes it from the mixer.
+func (m *Mixer) removeSound(s *sound) {
+	for i := range m.sounds {
+		if m.sounds[i] == s {
+			m.sounds = append(m.sounds[:i], m.sounds[i+1:]...)
+			break
+		}
+	}
+	s.finish()
+}
+
+// advanceByFrames moves the mixer clock and the sounds forward by the given
+// number of played frames.
+func (m *Mixer) advanceByFrames(frameCount int) {
+	from := m.frames
+	m.frames += int64(frameCount)
+	for i := 0; i < len(m.sounds); i++ {
+		s := m.sounds[i]
+		s.advance(from, frameCount)
+		if s.faded || !s.paused && s.isOver() {
+			s.finish()
+			m.sounds = append(m.sounds[:i], m.sounds[i+1:]...)
+			i--
 		}
 	}
 }
@@ -1,6 +1,16 @@
 package mixer
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gonutz/mixer/wav"
+)
 
 func TestRounding(t *testing.T) {
 	p, n := float32(1.5), float32(-1.5)
@@ -9,3 +19,2132 @@ func TestRounding(t *testing.T) {
 		t.Error(pos, neg)
 	}
 }
+
+func TestOfflineRenderingMixesScheduledSounds(t *testing.T) {
+	if err := InitOffline(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+
+	data := constantWave(1000).Data
+	sound := newSource(t, constantWave(1000)).PlayOnce()
+
+	w, err := Render(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Data) != 44100*4 {
+		t.Fatal("wrong output size", len(w.Data))
+	}
+	for i := 0; i < len(w.Data); i += 2 {
+		want := [2]byte{0x00, 0x40}
+		if i >= len(data) {
+			want = [2]byte{0, 0}
+		}
+		if w.Data[i] != want[0] || w.Data[i+1] != want[1] {
+			t.Fatalf("at byte %d got %v %v want %v", i, w.Data[i], w.Data[i+1], want)
+		}
+	}
+	if !sound.Stopped() {
+		t.Error("sound should be stopped after rendering past its end")
+	}
+}
+
+func TestPackageFunctionsUseDefaultMixer(t *testing.T) {
+	if err := InitOffline(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+
+	if _, err := Render(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if now := Default().Now(); now != 441 {
+		t.Error("default mixer is at", now)
+	}
+}
+
+func TestNullBackendAdvancesSoundsDeterministically(t *testing.T) {
+	backend := NewNullBackend()
+	if err := Init(backend, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+
+	sound := newSource(t, constantWave(22050)).PlayOnce() // half a second
+
+	backend.Advance(100 * time.Millisecond)
+	if pos := sound.Position(); pos != 100*time.Millisecond {
+		t.Error("position after 100ms is", pos)
+	}
+	if !sound.Playing() {
+		t.Error("sound should still be playing")
+	}
+
+	backend.Advance(500 * time.Millisecond)
+	if !sound.Stopped() {
+		t.Error("sound should be stopped")
+	}
+
+	out := backend.Output()
+	if len(out.Data) != 44100*4*6/10 {
+		t.Fatal("wrong output size", len(out.Data))
+	}
+	// the sound is only heard starting with the second update, the first
+	// update period of it is skipped
+	start := 441 * 4
+	for i := 0; i < len(out.Data); i += 2 {
+		want := [2]byte{0, 0}
+		if start <= i && i < 22050*4 {
+			want = [2]byte{0x00, 0x40}
+		}
+		if out.Data[i] != want[0] || out.Data[i+1] != want[1] {
+			t.Fatalf("at byte %d got %v %v want %v", i, out.Data[i], out.Data[i+1], want)
+		}
+	}
+}
+
+func TestNullBackendOutputWrapsAndCanBeCleared(t *testing.T) {
+	backend := NewNullBackend()
+	m := New()
+	err := m.Init(backend, &Options{
+		WriteAhead:     20 * time.Millisecond,
+		BufferDuration: 45 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	newMixerSource(t, m, constantWave(44100)).PlayOnce()
+	// the ring buffer wraps around several times, but not at an update step
+	backend.Advance(200 * time.Millisecond)
+	out := backend.Output().Data
+	if len(out) != 8820*4 {
+		t.Fatal("wrong output size", len(out))
+	}
+	for i := 441 * 4; i < len(out); i += 2 {
+		if out[i] != 0x00 || out[i+1] != 0x40 {
+			t.Fatalf("at byte %d got %v %v", i, out[i], out[i+1])
+		}
+	}
+
+	backend.ClearOutput()
+	backend.Advance(10 * time.Millisecond)
+	if n := len(backend.Output().Data); n != 441*4 {
+		t.Error("output size after clearing is", n)
+	}
+}
+
+// constantWave creates a 44100 Hz, 2 channel, 16 bit wave with the given
+// number of samples that all have the value 16384.
+func constantWave(sampleCount int) *wav.Wave {
+	data := make([]byte, sampleCount*4)
+	for i := 0; i < len(data); i += 2 {
+		data[i], data[i+1] = 0x00, 0x40
+	}
+	return &wav.Wave{
+		ChannelCount:     2,
+		SamplesPerSecond: 44100,
+		BitsPerSample:    16,
+		Data:             data,
+	}
+}
+
+// full is the value of the constantWave samples as mixed output.
+const full = float32(16384.0 / 32767.0)
+
+func newSource(t *testing.T, w *wav.Wave) SoundSource {
+	source, err := NewSoundSource(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+// newMixerSource is like newSource but creates the source for mixer m.
+func newMixerSource(t testing.TB, m *Mixer, w *wav.Wave) SoundSource {
+	source, err := m.NewSoundSource(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+func TestOutputFormatsKeepSoundTimingCorrect(t *testing.T) {
+	formats := []Options{
+		{SamplesPerSecond: 22050, ChannelCount: 1, SampleFormat: Int16},
+		{SamplesPerSecond: 44100, ChannelCount: 2, SampleFormat: Int24},
+		{SamplesPerSecond: 48000, ChannelCount: 2, SampleFormat: Float32},
+		{SamplesPerSecond: 96000, ChannelCount: 1, SampleFormat: Float32},
+	}
+	for _, opts := range formats {
+		opts := opts
+		if err := InitOffline(&opts); err != nil {
+			t.Fatal(err)
+		}
+
+		sound := newSource(t, constantWave(44100)).PlayOnce()
+		out, err := Render(500 * time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		frameSize := opts.ChannelCount * opts.SampleFormat.BitsPerSample() / 8
+		if len(out.Data) != opts.SamplesPerSecond/2*frameSize {
+			t.Error(opts, "wrong output size", len(out.Data))
+		}
+		if out.SamplesPerSecond != opts.SamplesPerSecond ||
+			out.ChannelCount != opts.ChannelCount ||
+			out.BitsPerSample != opts.SampleFormat.BitsPerSample() {
+			t.Error(opts, "wrong output format", out)
+		}
+		if l := sound.Length(); l != time.Second {
+			t.Error(opts, "length is", l)
+		}
+		if pos := sound.Position(); pos < 500*time.Millisecond-time.Second/44100 ||
+			pos > 500*time.Millisecond+time.Second/44100 {
+			t.Error(opts, "position is", pos)
+		}
+		sound.SetPosition(250 * time.Millisecond)
+		if pos := sound.Position(); pos != 250*time.Millisecond {
+			t.Error(opts, "position after SetPosition is", pos)
+		}
+		// let the sound end so it does not play in the next format
+		sound.SetPosition(time.Second)
+		Render(time.Millisecond)
+		Close()
+	}
+}
+
+func TestInvalidOutputFormatsAreRejected(t *testing.T) {
+	formats := []Options{
+		{SamplesPerSecond: 8000},
+		{ChannelCount: 3},
+		{SampleFormat: SampleFormat(7)},
+		{UpdateInterval: time.Microsecond},
+		{WriteAhead: 10 * time.Millisecond, UpdateInterval: 10 * time.Millisecond},
+		{WriteAhead: 100 * time.Millisecond, BufferDuration: 150 * time.Millisecond},
+	}
+	for _, opts := range formats {
+		opts := opts
+		if err := InitOffline(&opts); err == nil {
+			Close()
+			t.Error(opts, "error expected")
+		}
+	}
+}
+
+func TestMixersAreIndependent(t *testing.T) {
+	a, b := New(), New()
+	if err := a.InitOffline(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if err := b.InitOffline(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	sourceA := newMixerSource(t, a, constantWave(44100))
+	soundA := sourceA.PlayOnce()
+
+	outA, err := a.Render(100 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outB, err := b.Render(100 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pos := soundA.Position(); pos != 100*time.Millisecond {
+		t.Error("position is", pos)
+	}
+	if outA.Data[0] != 0x00 || outA.Data[1] != 0x40 {
+		t.Error("mixer a should play the sound", outA.Data[:2])
+	}
+	for i := range outB.Data {
+		if outB.Data[i] != 0 {
+			t.Fatal("mixer b should be silent")
+		}
+	}
+}
+
+func TestReadAdvancesSoundsByRequestedSamples(t *testing.T) {
+	m := New()
+	if err := m.InitOffline(&Options{ChannelCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(10000))
+	sound := source.PlayOnce()
+
+	p := make([]float32, 4410+9000)
+	n, err := m.Read(p[:4410])
+	if n != 4410 || err != nil {
+		t.Fatal(n, err)
+	}
+	if pos := sound.Position(); pos != 100*time.Millisecond {
+		t.Error("position is", pos)
+	}
+	n, err = m.Read(p[4410:])
+	if n != 9000 || err != nil {
+		t.Fatal(n, err)
+	}
+	if !sound.Stopped() {
+		t.Error("sound should be stopped")
+	}
+	for i := range p {
+		want := full
+		if i >= 10000 {
+			want = 0
+		}
+		if p[i] != want {
+			t.Fatalf("at %d got %v want %v", i, p[i], want)
+		}
+	}
+}
+
+func TestRecordingContainsPlayedOutput(t *testing.T) {
+	backend := NewNullBackend()
+	m := New()
+	if err := m.Init(backend, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(4410))
+	backend.Advance(20 * time.Millisecond)
+	var file memoryFile
+	if err := m.StartRecording(&file); err != nil {
+		t.Fatal(err)
+	}
+	source.PlayOnce()
+	backend.Advance(200 * time.Millisecond)
+	if err := m.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+	backend.Advance(20 * time.Millisecond)
+
+	recorded, err := wav.Read(bytes.NewReader(file.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	played := backend.Output().Data
+	played = played[882*4 : 9702*4] // from 20ms to 220ms
+	if !bytes.Equal(recorded.Data, played) {
+		t.Error("recording differs from played output")
+	}
+}
+
+// memoryFile is an in-memory io.WriteSeeker.
+type memoryFile struct {
+	data   []byte
+	offset int
+}
+
+func (f *memoryFile) Write(p []byte) (int, error) {
+	for len(f.data) < f.offset+len(p) {
+		f.data = append(f.data, 0)
+	}
+	copy(f.data[f.offset:], p)
+	f.offset += len(p)
+	return len(p), nil
+}
+
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.offset = int(offset)
+	case io.SeekCurrent:
+		f.offset += int(offset)
+	case io.SeekEnd:
+		f.offset = len(f.data) + int(offset)
+	}
+	return int64(f.offset), nil
+}
+
+func TestLatencyDependsOnUpdateInterval(t *testing.T) {
+	backend := NewNullBackend()
+	m := New()
+	err := m.Init(backend, &Options{
+		WriteAhead:     20 * time.Millisecond,
+		UpdateInterval: 5 * time.Millisecond,
+		BufferDuration: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if l := m.Latency(); l != 5*time.Millisecond {
+		t.Error("latency is", l)
+	}
+
+	source := newMixerSource(t, m, constantWave(44100))
+	sound := source.PlayOnce()
+	backend.Advance(300 * time.Millisecond)
+	if pos := sound.Position(); pos != 300*time.Millisecond {
+		t.Error("position is", pos)
+	}
+	out := backend.Output().Data
+	if len(out) != 300*441/10*4 {
+		t.Fatal("wrong output size", len(out))
+	}
+	// with a 5ms update interval, the sound starts after 5ms (221 samples)
+	for i := 0; i < len(out); i += 2 {
+		want := [2]byte{0x00, 0x40}
+		if i < 221*4 {
+			want = [2]byte{0, 0}
+		}
+		if out[i] != want[0] || out[i+1] != want[1] {
+			t.Fatalf("at byte %d got %v %v want %v", i, out[i], out[i+1], want)
+		}
+	}
+}
+
+func TestStallsAreCountedAsUnderruns(t *testing.T) {
+	backend := NewNullBackend()
+	m := New()
+	if err := m.Init(backend, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	backend.Advance(100 * time.Millisecond)
+	if s := m.Stats(); s.Updates != 10 || s.Underruns != 0 || s.AverageJitter != 0 {
+		t.Error("unexpected stats", s)
+	}
+
+	backend.Stall(150 * time.Millisecond)
+	backend.Stall(130 * time.Millisecond)
+	backend.Advance(30 * time.Millisecond)
+	s := m.Stats()
+	if s.Updates != 15 {
+		t.Error("updates", s.Updates)
+	}
+	if s.Underruns != 2 {
+		t.Error("underruns", s.Underruns)
+	}
+	if s.LongestUnderrun != 50*time.Millisecond {
+		t.Error("longest underrun", s.LongestUnderrun)
+	}
+	if s.TotalUnderrun != 80*time.Millisecond {
+		t.Error("total underrun", s.TotalUnderrun)
+	}
+	if s.AverageJitter != (140+120)*time.Millisecond/15 {
+		t.Error("average jitter", s.AverageJitter)
+	}
+}
+
+func TestBackendIsReopenedAfterErrors(t *testing.T) {
+	backend := &clockBackend{failOpens: 1}
+	errs := make(chan error, 100)
+	recovered := make(chan bool, 1)
+	m := New()
+	err := m.Init(backend, &Options{Recovery: &Recovery{
+		Retries: 2,
+		Backoff: time.Millisecond,
+		OnError: func(err error, fatal bool) {
+			if fatal {
+				t.Error("recovery should not give up")
+			}
+			errs <- err
+		},
+		OnRecovered: func() { recovered <- true },
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	backend.fail(3)
+	select {
+	case <-recovered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("mixer did not recover")
+	}
+
+	// 2 retries, then a failed re-open and a successful one
+	for i := 0; i < 4; i++ {
+		select {
+		case <-errs:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected 4 errors but got", i)
+		}
+	}
+	if err := m.Error(); err != nil {
+		t.Error("error after recovery:", err)
+	}
+	if backend.openCount() != 3 {
+		t.Error("backend should have been opened 3 times but was", backend.openCount())
+	}
+}
+
+// clockBackend plays its ring buffer in real time, like a sound card. It can
+// be made to fail.
+type clockBackend struct {
+	lock           sync.Mutex
+	start          time.Time
+	bytesPerSecond float64
+	frameSize      uint
+	size           uint
+	opens          int
+	// failPositions is the number of following calls to Positions that fail
+	failPositions int
+	// failOpens is the number of calls to Open that fail, except the first
+	failOpens int
+}
+
+func (b *clockBackend) fail(n int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failPositions = n
+}
+
+func (b *clockBackend) openCount() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.opens
+}
+
+func (b *clockBackend) Open(f Format, bufferSize uint) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.opens++
+	if b.opens > 1 && b.failOpens > 0 {
+		b.failOpens--
+		return errors.New("open failed")
+	}
+	b.bytesPerSecond = float64(f.SamplesPerSecond * f.FrameSize())
+	b.frameSize = uint(f.FrameSize())
+	b.size = bufferSize
+	return nil
+}
+
+func (b *clockBackend) BufferSize() uint {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.size
+}
+
+func (b *clockBackend) Positions() (play, write uint, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.failPositions > 0 {
+		b.failPositions--
+		return 0, 0, errors.New("positions failed")
+	}
+	pos := uint(time.Since(b.start).Seconds() * b.bytesPerSecond)
+	pos -= pos % b.frameSize
+	return pos % b.size, pos % b.size, nil
+}
+
+func (b *clockBackend) Write(data []byte, offset uint) error {
+	return nil
+}
+
+func (b *clockBackend) Start() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.start = time.Now()
+	return nil
+}
+
+func (b *clockBackend) Stop() error {
+	return nil
+}
+
+func (b *clockBackend) Close() error {
+	return nil
+}
+
+func TestFailedMixerCanBeClosedAndRestarted(t *testing.T) {
+	backend := &clockBackend{}
+	m := New()
+	if err := m.Init(backend, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := m.State(); s != Running {
+		t.Fatal("state after Init is", s)
+	}
+
+	backend.fail(1)
+	for start := time.Now(); m.State() != Failed; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("mixer did not fail")
+		}
+	}
+	if m.Error() == nil {
+		t.Error("failed mixer should report an error")
+	}
+	if err := m.Init(backend, nil); err == nil {
+		t.Error("failed mixer should not be initialized again before Close")
+	}
+
+	m.Close()
+	if s := m.State(); s != Stopped {
+		t.Error("state after Close is", s)
+	}
+
+	if err := m.Init(backend, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Error(); err != nil {
+		t.Error("error after re-Init:", err)
+	}
+	m.Close()
+}
+
+func TestClosePolicyReleasesOrKeepsSounds(t *testing.T) {
+	for _, policy := range []ClosePolicy{ReleaseSounds, KeepSounds} {
+		backend := NewNullBackend()
+		m := New()
+		if err := m.Init(backend, &Options{ClosePolicy: policy}); err != nil {
+			t.Fatal(err)
+		}
+		source := newMixerSource(t, m, constantWave(44100))
+		sound := source.PlayOnce()
+		backend.Advance(100 * time.Millisecond)
+		m.Close()
+
+		if policy == ReleaseSounds {
+			if !sound.Stopped() {
+				t.Error("sound should be released on Close")
+			}
+			continue
+		}
+
+		if sound.Stopped() {
+			t.Error("sound should be kept on Close")
+		}
+		if err := m.Init(backend, nil); err != nil {
+			t.Fatal(err)
+		}
+		if pos := sound.Position(); pos != 100*time.Millisecond {
+			t.Error("kept sound should not move but is at", pos)
+		}
+		backend.Advance(100 * time.Millisecond)
+		if pos := sound.Position(); pos != 200*time.Millisecond {
+			t.Error("kept sound should continue but is at", pos)
+		}
+		m.Close()
+	}
+}
+
+// simBackend plays its ring buffer by a simulated clock that only moves when
+// the test advances it. Like the NullBackend, it keeps Init from starting the
+// update Go routine, so the test runs the mixer's updates itself.
+type simBackend struct {
+	now            time.Time
+	start          time.Time
+	speed          float64
+	bytesPerSecond float64
+	frameSize      uint
+	size           uint
+}
+
+func newSimBackend(speed float64) *simBackend {
+	return &simBackend{now: time.Unix(0, 0), speed: speed}
+}
+
+func (b *simBackend) clock() time.Time {
+	return b.now
+}
+
+func (b *simBackend) setUpdate(func() bool, time.Duration) {}
+
+func (b *simBackend) Open(f Format, bufferSize uint) error {
+	b.bytesPerSecond = float64(f.SamplesPerSecond * f.FrameSize())
+	b.frameSize = uint(f.FrameSize())
+	b.size = bufferSize
+	return nil
+}
+
+func (b *simBackend) BufferSize() uint {
+	return b.size
+}
+
+func (b *simBackend) Positions() (play, write uint, err error) {
+	pos := uint(b.now.Sub(b.start).Seconds() * b.bytesPerSecond * b.speed)
+	pos -= pos % b.frameSize
+	return pos % b.size, pos % b.size, nil
+}
+
+func (b *simBackend) Write(data []byte, offset uint) error {
+	return nil
+}
+
+func (b *simBackend) Start() error {
+	b.start = b.now
+	return nil
+}
+
+func (b *simBackend) Stop() error {
+	return nil
+}
+
+func (b *simBackend) Close() error {
+	return nil
+}
+
+func TestUpdatesAreScheduledByConsumedData(t *testing.T) {
+	// the device clock may be off, the mixer adapts to the measured rate
+	for _, speed := range []float64{1, 1.2, 0.8} {
+		backend := newSimBackend(speed)
+		m := New()
+		m.sched.now = backend.clock
+		if err := m.Init(backend, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		for backend.now.Sub(backend.start) < time.Second {
+			backend.now = backend.now.Add(m.nextWakeup())
+			m.update()
+		}
+		m.Close()
+
+		// with the default 100ms write-ahead, the mixer wakes up whenever
+		// 50ms were played, polling every millisecond would be 1000 times
+		s := m.Stats()
+		if want := int(20 * speed); s.Wakeups < want-2 || s.Wakeups > want+2 {
+			t.Error("speed", speed, "wakeups:", s.Wakeups)
+		}
+		if s.Updates != s.Wakeups {
+			t.Error("speed", speed, "only", s.Updates, "of", s.Wakeups,
+				"wakeups were updates")
+		}
+		if s.Underruns != 0 {
+			t.Error("speed", speed, "underruns:", s.Underruns)
+		}
+	}
+}
+
+func TestWakeupIsTimedByQueuedData(t *testing.T) {
+	backend := newSimBackend(1)
+	m := New()
+	m.sched.now = backend.clock
+	if err := m.Init(backend, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// the mixer wakes up when half of the 100ms write-ahead is left
+	if d := m.nextWakeup(); d != 50*time.Millisecond {
+		t.Error("first wakeup after", d)
+	}
+	backend.now = backend.now.Add(20 * time.Millisecond)
+	if d := m.nextWakeup(); d != 30*time.Millisecond {
+		t.Error("wakeup after 20ms is after", d)
+	}
+	// a late wakeup is not delayed any further
+	backend.now = backend.now.Add(60 * time.Millisecond)
+	if d := m.nextWakeup(); d != time.Millisecond {
+		t.Error("overdue wakeup after", d)
+	}
+	// the late update writes a whole new write-ahead
+	m.update()
+	if d := m.nextWakeup(); d != 50*time.Millisecond {
+		t.Error("wakeup after the late update after", d)
+	}
+}
+
+// notifyBackend notifies the mixer only when its notify channel is signalled.
+type notifyBackend struct {
+	clockBackend
+	notify chan struct{}
+}
+
+func (b *notifyBackend) Notify() <-chan struct{} {
+	return b.notify
+}
+
+func TestNotifierWakesUpMixer(t *testing.T) {
+	backend := &notifyBackend{notify: make(chan struct{})}
+	m := New()
+	err := m.Init(backend, &Options{
+		// the fallback wakeup is after 5 seconds
+		WriteAhead:     10 * time.Second,
+		BufferDuration: 20 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	for i := 0; i < 3; i++ {
+		backend.notify <- struct{}{}
+	}
+	// the third notification is received after the mixer handled the second
+	// one
+	if w := m.Stats().Wakeups; w < 2 {
+		t.Error("wakeups:", w)
+	}
+}
+
+func TestChangesWakeUpMixer(t *testing.T) {
+	backend := &clockBackend{}
+	m := New()
+	err := m.Init(backend, &Options{
+		// the data runs low after 5 seconds
+		WriteAhead:     10 * time.Second,
+		BufferDuration: 20 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	m.SetVolume(0.5)
+	for start := time.Now(); m.Stats().Wakeups == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 4*time.Second {
+			t.Fatal("mixer did not wake up for the change")
+		}
+	}
+}
+
+func BenchmarkUpdateLoopWakeups(b *testing.B) {
+	backend := &clockBackend{}
+	m := New()
+	if err := m.Init(backend, nil); err != nil {
+		b.Fatal(err)
+	}
+	// as a baseline, the polling loop that the mixer used before the
+	// scheduler runs over the same time
+	stop := make(chan bool)
+	polls := make(chan int)
+	go func() { polls <- pollingWakeups(m.updateInterval, stop) }()
+
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	elapsed := time.Since(start)
+	m.Close()
+
+	b.ReportMetric(float64(m.Stats().Wakeups)/elapsed.Seconds(), "wakeups/s")
+	b.ReportMetric(float64(<-polls)/elapsed.Seconds(), "poll-wakeups/s")
+}
+
+// pollingWakeups runs the timing of the fixed 1ms polling update loop, which
+// the scheduler replaced, until stop is closed. It returns the number of times
+// that the loop woke up.
+func pollingWakeups(updateInterval time.Duration, stop chan bool) int {
+	pulse := time.NewTicker(updateInterval)
+	defer pulse.Stop()
+
+	wakeups := 0
+	for {
+		wakeups++
+		select {
+		case <-pulse.C:
+		case <-stop:
+			return wakeups
+		default:
+			time.Sleep(1 * time.Millisecond)
+		}
+	}
+}
+
+func TestLoopingSoundsWrapWithoutGaps(t *testing.T) {
+	m := New()
+	if err := m.InitOffline(&Options{ChannelCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, rampWave(100))
+	sound := source.PlayLooping(3)
+	forever := source.PlayForeverLooping()
+	forever.SetVolume(0)
+
+	if l := sound.Length(); l != samplesToDuration(300, 44100) {
+		t.Error("length is", l)
+	}
+	if l := forever.Length(); l != time.Duration(math.MaxInt64) {
+		t.Error("forever length is", l)
+	}
+
+	p := make([]float32, 250)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	if pos := sound.Position(); pos != samplesToDuration(250, 44100) {
+		t.Error("position is", pos)
+	}
+	q := make([]float32, 100)
+	if _, err := m.Read(q); err != nil {
+		t.Fatal(err)
+	}
+	p = append(p, q...)
+	for i := range p {
+		want := float32(0)
+		if i < 300 {
+			want = float32((i%100)*256) / 32767
+		}
+		if p[i] != want {
+			t.Fatalf("at %d got %v want %v", i, p[i], want)
+		}
+	}
+	if !sound.Stopped() {
+		t.Error("sound should be stopped after 3 loops")
+	}
+	if !forever.Playing() {
+		t.Error("forever looping sound should still be playing")
+	}
+	if pos := forever.Position(); pos != samplesToDuration(350, 44100) {
+		t.Error("forever position is", pos)
+	}
+
+	forever.SetPosition(samplesToDuration(1050, 44100))
+	if pos := forever.Position(); pos != samplesToDuration(1050, 44100) {
+		t.Error("forever position after SetPosition is", pos)
+	}
+}
+
+// rampWave creates a 44100 Hz, 2 channel, 16 bit wave with the given number of
+// samples, which must be at most 128. The samples have the values 0, 256, 512
+// and so on.
+func rampWave(sampleCount int) *wav.Wave {
+	w := constantWave(sampleCount)
+	for i := 0; i < sampleCount; i++ {
+		for c := 0; c < 2; c++ {
+			w.Data[i*4+c*2], w.Data[i*4+c*2+1] = 0, byte(i)
+		}
+	}
+	return w
+}
+
+// rampIndex returns the sample index of the rampWave sample v.
+func rampIndex(v float32) int {
+	return int(v*32767/256 + 0.5)
+}
+
+func TestLoopRegionRepeatsBetweenIntroAndTail(t *testing.T) {
+	m := New()
+	if err := m.InitOffline(&Options{ChannelCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, rampWave(100))
+	source.SetLoopRegion(20, 60)
+	sound := source.PlayLooping(3)
+	if l := sound.Length(); l != samplesToDuration(180, 44100) {
+		t.Error("length is", l)
+	}
+
+	p := make([]float32, 200)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	var want []int
+	for i := 0; i < 60; i++ {
+		want = append(want, i)
+	}
+	for loop := 0; loop < 2; loop++ {
+		for i := 20; i < 60; i++ {
+			want = append(want, i)
+		}
+	}
+	for i := 60; i < 100; i++ {
+		want = append(want, i)
+	}
+	for i := range p {
+		if i >= len(want) {
+			if p[i] != 0 {
+				t.Fatalf("at %d got %v after the sound ended", i, p[i])
+			}
+		} else if got := rampIndex(p[i]); got != want[i] {
+			t.Fatalf("at %d got sample %d want %d", i, got, want[i])
+		}
+	}
+	if !sound.Stopped() {
+		t.Error("sound should be stopped")
+	}
+}
+
+func TestReleasedLoopPlaysTail(t *testing.T) {
+	m := New()
+	if err := m.InitOffline(&Options{ChannelCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, rampWave(100))
+	source.SetLoopRegion(20, 60)
+	sound := source.PlayForeverLooping()
+
+	p := make([]float32, 90)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	if got := rampIndex(p[89]); got != 49 {
+		t.Fatal("before release the sample is", got)
+	}
+	sound.ReleaseLoop()
+	if l := sound.Length(); l != samplesToDuration(140, 44100) {
+		t.Error("length after release is", l)
+	}
+
+	p = make([]float32, 60)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if got := rampIndex(p[i]); got != 50+i {
+			t.Fatalf("at %d got sample %d want %d", i, got, 50+i)
+		}
+	}
+	if p[50] != 0 || !sound.Stopped() {
+		t.Error("sound should be stopped after the tail")
+	}
+}
+
+func TestLoopCrossfadeSmoothsSeam(t *testing.T) {
+	for _, crossfade := range []int{0, 10} {
+		m := New()
+		if err := m.InitOffline(&Options{ChannelCount: 1}); err != nil {
+			t.Fatal(err)
+		}
+
+		source := newMixerSource(t, m, rampWave(100))
+		source.SetLoopRegion(20, 60)
+		source.SetLoopCrossfade(samplesToDuration(float64(crossfade), 44100))
+		source.PlayLooping(2)
+
+		p := make([]float32, 100)
+		if _, err := m.Read(p); err != nil {
+			t.Fatal(err)
+		}
+		m.Close()
+
+		// the ramp rises by 1 sample per frame, without a crossfade it
+		// drops by 40 at the seam
+		jump := rampIndex(p[59]) - rampIndex(p[60])
+		if crossfade == 0 && jump != 39 {
+			t.Error("without crossfade the seam jumps by", jump)
+		}
+		if crossfade > 0 && (jump < -1 || jump > 1) {
+			t.Error("with crossfade the seam jumps by", jump)
+		}
+		// only the end of the loop region is crossfaded
+		if got := rampIndex(p[49]); got != 49 {
+			t.Error("sample 49 is", got)
+		}
+	}
+}
+
+func TestLoopCrossfadeOfWholeData(t *testing.T) {
+	m := New()
+	if err := m.InitOffline(&Options{ChannelCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, rampWave(100))
+	crossfade := samplesToDuration(10, 44100)
+	source.SetLoopCrossfade(crossfade)
+	if d := source.LoopCrossfade(); d != crossfade {
+		t.Error("loop crossfade is", d)
+	}
+	sound := source.PlayLooping(2)
+
+	p := make([]float32, 200)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	// there is no data before the loop, the end fades over to the first 10
+	// samples and the second loop continues after them
+	if got := rampIndex(p[89]); got != 89 {
+		t.Error("sample 89 is", got)
+	}
+	if got := rampIndex(p[99]); got != 9 {
+		t.Error("end of first loop is", got)
+	}
+	if got := rampIndex(p[100]); got != 10 {
+		t.Error("start of second loop is", got)
+	}
+	if got := rampIndex(p[189]); got != 99 {
+		t.Error("end of second loop is", got)
+	}
+	if p[190] != 0 || !sound.Stopped() {
+		t.Error("sound should be stopped after the second loop")
+	}
+
+	// the crossfade is limited to half the loop
+	source.SetLoopCrossfade(time.Second)
+	if d := source.LoopCrossfade(); d != samplesToDuration(50, 44100) {
+		t.Error("limited loop crossfade is", d)
+	}
+}
+
+func TestPitchScalesPlaybackRate(t *testing.T) {
+	m := New()
+	if err := m.InitOffline(&Options{ChannelCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(4410))
+	source.SetPitch(2)
+	fast := source.PlayOnce()
+	slow := source.PlayOnce()
+	slow.SetPitch(0.5)
+	if fast.Pitch() != 2 || slow.Pitch() != 0.5 {
+		t.Fatal("pitches are", fast.Pitch(), slow.Pitch())
+	}
+
+	p := make([]float32, 2205)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	if !fast.Stopped() {
+		t.Error("sound at pitch 2 should be stopped after half its length")
+	}
+	if pos := slow.Position(); pos != 25*time.Millisecond {
+		t.Error("position at pitch 0.5 is", pos)
+	}
+	if l := slow.Length(); l != 100*time.Millisecond {
+		t.Error("length at pitch 0.5 is", l)
+	}
+}
+
+func TestHighPitchWrapsShortLoops(t *testing.T) {
+	m := New()
+	if err := m.InitOffline(&Options{ChannelCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(20))
+	source.SetLoopRegion(5, 8)
+	source.SetPitch(10)
+	sound := source.PlayForeverLooping()
+
+	// every step is longer than the loop
+	for read := 0; read < 3; read++ {
+		p := make([]float32, 40)
+		if _, err := m.Read(p); err != nil {
+			t.Fatal(err)
+		}
+		for i := range p {
+			if p[i] == 0 {
+				t.Fatalf("read %d is silent at %d", read, i)
+			}
+		}
+	}
+	if !sound.Playing() {
+		t.Error("looping sound should still be playing")
+	}
+}
+
+func TestInterpolationBetweenSamples(t *testing.T) {
+	for _, interpolation := range []Interpolation{Nearest, Linear, Cubic, Sinc} {
+		m := New()
+		err := m.InitOffline(&Options{
+			ChannelCount:  1,
+			SampleFormat:  Float32,
+			Interpolation: interpolation,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		source := newMixerSource(t, m, rampWave(100))
+		source.SetPitch(0.5)
+		source.PlayOnce()
+		p := make([]float32, 100)
+		if _, err := m.Read(p); err != nil {
+			t.Fatal(err)
+		}
+		m.Close()
+
+		// odd output samples lie halfway between two source samples, check
+		// them away from the start where the ramp begins
+		for k := 10; k < 40; k++ {
+			got := p[2*k+1] * 32767 / 256
+			want := float32(k) + 0.5
+			if interpolation == Nearest {
+				want = float32(k + 1)
+			}
+			if d := got - want; d < -0.01 || d > 0.01 {
+				t.Errorf("%v at %v: got %v want %v", interpolation, want, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestVolumeAndPanChangesAreRamped(t *testing.T) {
+	for _, smoothing := range []time.Duration{0, -1} {
+		m := New()
+		if err := m.InitOffline(&Options{Smoothing: smoothing}); err != nil {
+			t.Fatal(err)
+		}
+		source := newMixerSource(t, m, constantWave(44100))
+		sound := source.PlayOnce()
+		p := make([]float32, 2*100)
+		if _, err := m.Read(p); err != nil {
+			t.Fatal(err)
+		}
+
+		sound.SetPan(1)
+		m.SetVolume(0.5)
+		p = make([]float32, 2*441)
+		if _, err := m.Read(p); err != nil {
+			t.Fatal(err)
+		}
+		m.Close()
+
+		left := func(i int) float32 { return p[2*i] }
+		right := func(i int) float32 { return p[2*i+1] }
+		if smoothing < 0 {
+			if left(0) != 0 || right(0) != full/2 {
+				t.Error("without smoothing the change should be immediate",
+					left(0), right(0))
+			}
+			continue
+		}
+		// the default smoothing of 5ms is 221 frames
+		if left(0) < full*0.99 || right(0) < full*0.99 {
+			t.Error("ramps should start at the old values", left(0), right(0))
+		}
+		for i := 1; i < 221; i++ {
+			if left(i) >= left(i-1) || right(i) >= right(i-1) {
+				t.Fatal("ramps should fall smoothly at frame", i)
+			}
+		}
+		if d := right(110) - full*0.75; d < -0.01 || d > 0.01 {
+			t.Error("master volume should be about halfway at frame 110", right(110))
+		}
+		for i := 221; i < 441; i++ {
+			if left(i) != 0 || right(i) != full/2 {
+				t.Fatal("ramps should end at frame 221 but at", i, "are",
+					left(i), right(i))
+			}
+		}
+	}
+}
+
+func TestFadesFollowTheirCurves(t *testing.T) {
+	for _, curve := range []Curve{LinearCurve, ExponentialCurve, EqualPowerCurve} {
+		m := New()
+		if err := m.InitOffline(&Options{ChannelCount: 1}); err != nil {
+			t.Fatal(err)
+		}
+		source := newMixerSource(t, m, constantWave(44100))
+		sound := source.PlayOnce()
+		sound.FadeTo(0.25, 10*time.Millisecond, curve)
+		if !sound.Fading() {
+			t.Error(curve, "sound should be fading")
+		}
+		if v := sound.Volume(); v != 0.25 {
+			t.Error(curve, "volume should be the fade target but is", v)
+		}
+
+		p := make([]float32, 882)
+		if _, err := m.Read(p); err != nil {
+			t.Fatal(err)
+		}
+		if sound.Fading() {
+			t.Error(curve, "fade should be over")
+		}
+		m.Close()
+
+		// 10ms are 441 frames, check the middle of the fade
+		want := map[Curve]float32{
+			LinearCurve:      0.625,
+			ExponentialCurve: 0.5,
+			EqualPowerCurve:  float32(0.25 + 0.75*math.Cos(math.Pi/4)),
+		}[curve]
+		if d := p[220]/full - want; d < -0.01 || d > 0.01 {
+			t.Error(curve, "in the middle of the fade the volume is", p[220]/full)
+		}
+		for i := 441; i < len(p); i++ {
+			if p[i] != full*0.25 {
+				t.Fatal(curve, "after the fade the volume is", p[i]/full)
+			}
+		}
+	}
+}
+
+func TestFadeOutAndStop(t *testing.T) {
+	backend := NewNullBackend()
+	m := New()
+	if err := m.Init(backend, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(44100))
+	sound := source.PlayOnce()
+	backend.Advance(50 * time.Millisecond)
+	sound.FadeOutAndStop(100 * time.Millisecond)
+	backend.Advance(90 * time.Millisecond)
+	if sound.Stopped() || !sound.Fading() {
+		t.Error("sound should still be fading")
+	}
+	backend.Advance(20 * time.Millisecond)
+	if !sound.Stopped() {
+		t.Error("sound should be stopped after the fade")
+	}
+}
+
+func TestStopFadesOutAndRemovesSound(t *testing.T) {
+	backend := NewNullBackend()
+	m := New()
+	if err := m.Init(backend, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(44100))
+	sound := source.PlayOnce()
+	backend.Advance(50 * time.Millisecond)
+	sound.Stop()
+	sound.SetVolume(1)
+	if sound.Stopped() {
+		t.Error("sound should fade out before it stops")
+	}
+	backend.Advance(20 * time.Millisecond)
+	if !sound.Stopped() {
+		t.Fatal("sound should be stopped")
+	}
+
+	// the change is heard after one update interval, then the output falls to
+	// silence over the 5ms smoothing time
+	out := backend.Output().Data
+	last := 60 * 441 / 10 * 4
+	if v := out[last+1]; v != 0x40 {
+		t.Error("fade should start at full volume but starts with", v)
+	}
+	for i := last; i < last+221*4; i += 4 {
+		v := int16(out[i]) | int16(out[i+1])<<8
+		prev := int16(out[i-4]) | int16(out[i-3])<<8
+		if v > prev {
+			t.Fatal("output rises while fading out at byte", i)
+		}
+	}
+	for i := last + 221*4; i < len(out); i++ {
+		if out[i] != 0 {
+			t.Fatal("output should be silent after the fade at byte", i)
+		}
+	}
+}
+
+func TestStopAllStopsInstancesOfSource(t *testing.T) {
+	backend := NewNullBackend()
+	m := New()
+	if err := m.Init(backend, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(44100))
+	other := newMixerSource(t, m, constantWave(44100))
+	playing := source.PlayOnce()
+	paused := source.PlayPaused()
+	unrelated := other.PlayOnce()
+	backend.Advance(50 * time.Millisecond)
+
+	source.StopAll()
+	if !paused.Stopped() {
+		t.Error("paused sound should stop right away")
+	}
+	backend.Advance(20 * time.Millisecond)
+	if !playing.Stopped() {
+		t.Error("playing sound should be stopped")
+	}
+	if unrelated.Stopped() {
+		t.Error("sound of another source should not be stopped")
+	}
+}
+
+func TestFinishedSoundsNotifyListeners(t *testing.T) {
+	backend := NewNullBackend()
+	m := New()
+	if err := m.Init(backend, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	source := newMixerSource(t, m, constantWave(441*5)) // 50ms
+	// the next sound is longer so it still plays if the callback runs while
+	// the backend is advancing
+	nextSource := newMixerSource(t, m, constantWave(44100))
+	first := source.PlayOnce()
+	next := make(chan Sound, 1)
+	// the callback can play the next sound
+	first.OnFinished(func() { next <- nextSource.PlayOnce() })
+
+	select {
+	case <-first.Done():
+		t.Fatal("sound should not be done before it played")
+	default:
+	}
+	backend.Advance(100 * time.Millisecond)
+	select {
+	case <-first.Done():
+	default:
+		t.Fatal("Done should be closed after the sound finished")
+	}
+	var second Sound
+	select {
+	case second = <-next:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnFinished was not called")
+	}
+	backend.Advance(20 * time.Millisecond)
+	if !second.Playing() {
+		t.Error("chained sound should be playing")
+	}
+
+	called := make(chan bool, 1)
+	first.OnFinished(func() { called <- true })
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Error("OnFinished on a stopped sound should be called right away")
+	}
+
+	m.Close()
+	select {
+	case <-second.Done():
+	default:
+		t.Error("Close should finish the playing sounds")
+	}
+}
+
+// latencyBackend is a NullBackend whose play cursor lags behind the write
+// cursor by a fixed number of bytes.
+type latencyBackend struct {
+	*NullBackend
+	latency uint
+}
+
+func (b *latencyBackend) Positions() (play, write uint, err error) {
+	_, write, err = b.NullBackend.Positions()
+	size := b.BufferSize()
+	return (write + size - b.latency) % size, write, err
+}
+
+func TestPositionIsWhatIsHeard(t *testing.T) {
+	// 20ms latency at 44100 Hz stereo 16 bit
+	backend := &latencyBackend{NullBackend: NewNullBackend(), latency: 882 * 4}
+	m := New()
+	if err := m.Init(backend, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(44100))
+	sound := source.PlayOnce()
+	backend.Advance(10 * time.Millisecond)
+	if pos := sound.Position(); pos != 0 {
+		t.Error("sound that was not heard yet has position", pos)
+	}
+
+	backend.Advance(90 * time.Millisecond)
+	if pos := sound.Position(); pos != 80*time.Millisecond {
+		t.Error("position should lag 20ms behind but is", pos)
+	}
+	if c := m.Clock(); c != 80*time.Millisecond {
+		t.Error("clock is", c)
+	}
+
+	sound.SetPosition(500 * time.Millisecond)
+	if pos := sound.Position(); pos != 500*time.Millisecond {
+		t.Error("position right after SetPosition is", pos)
+	}
+
+	// a sound that is paused by its group stops at what was written
+	group, err := m.NewGroup("paused", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grouped := source.PlayOnce()
+	grouped.SetGroup(group)
+	backend.Advance(100 * time.Millisecond)
+	group.SetPaused(true)
+	backend.Advance(50 * time.Millisecond)
+	if pos := grouped.Position(); pos != 100*time.Millisecond {
+		t.Error("position of sound in paused group is", pos)
+	}
+}
+
+func TestPositionDoesNotRunAheadOfMixedData(t *testing.T) {
+	// the play cursor is at the write cursor, the clock runs ahead of it
+	// between updates
+	backend := &clockBackend{}
+	m := New()
+	if err := m.Init(backend, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(441)) // 10ms
+	sound := source.PlayOnce()
+	if pos := sound.Position(); pos > time.Millisecond {
+		t.Error("sound that was not mixed yet has position", pos)
+	}
+	for start := time.Now(); time.Since(start) < 100*time.Millisecond; {
+		if pos := sound.Position(); pos > sound.Length() {
+			t.Fatal("position", pos, "is past the length", sound.Length())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	long := source.PlayForeverLooping()
+	time.Sleep(20 * time.Millisecond)
+	long.SetPosition(5 * time.Millisecond)
+	if pos := long.Position(); pos < 5*time.Millisecond ||
+		pos > 6*time.Millisecond {
+		t.Error("position right after SetPosition is", pos)
+	}
+}
+
+func TestPlayAtStartsAtExactSample(t *testing.T) {
+	m := New()
+	if err := m.InitOffline(&Options{ChannelCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(44100))
+	p := make([]float32, 100)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	if now := m.Now(); now != 100 {
+		t.Fatal("clock is", now)
+	}
+
+	sound := source.PlayAt(m.Now() + 150)
+	if !sound.Paused() {
+		t.Error("sound should be paused until it starts")
+	}
+	p = make([]float32, 200)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	for i := range p {
+		want := full
+		if i < 150 {
+			want = 0
+		}
+		if p[i] != want {
+			t.Fatalf("at %d got %v want %v", i, p[i], want)
+		}
+	}
+	if sound.Paused() {
+		t.Error("sound should not be paused after it started")
+	}
+	if pos := sound.Position(); pos != samplesToDuration(50, 44100) {
+		t.Error("position is", pos)
+	}
+}
+
+func TestScheduledChangesApplyAtExactSamples(t *testing.T) {
+	m := New()
+	err := m.InitOffline(&Options{ChannelCount: 1, Smoothing: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(44100))
+	sound := source.PlayOnce()
+	sound.SetVolumeAt(0.5, 20)
+	sound.SetPausedAt(true, 40)
+	p := make([]float32, 60)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	sound.SetPausedAt(false, 80)
+	sound.StopAt(100)
+	q := make([]float32, 60)
+	if _, err := m.Read(q); err != nil {
+		t.Fatal(err)
+	}
+	p = append(p, q...)
+
+	for i := range p {
+		want := float32(0)
+		if i < 20 {
+			want = full
+		} else if i < 40 || 80 <= i && i < 100 {
+			want = full * 0.5
+		}
+		if p[i] != want {
+			t.Fatalf("at %d got %v want %v", i, p[i], want)
+		}
+	}
+	if !sound.Stopped() {
+		t.Error("sound should be stopped")
+	}
+}
+
+func TestBatchAppliesChangesTogether(t *testing.T) {
+	m := New()
+	err := m.InitOffline(&Options{ChannelCount: 1, Smoothing: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(44100))
+	source.SetVolume(0.5)
+	sounds := []Sound{source.PlayOnce(), source.PlayOnce(), source.PlayOnce()}
+	stopped := source.PlayPaused()
+	stopped.Stop()
+	other := New()
+	otherSource := newMixerSource(t, other, constantWave(100))
+	foreign := otherSource.PlayPaused()
+
+	p := make([]float32, 10)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	if p[9] != 3*0.5*full {
+		t.Fatal("before the batch the output is", p[9])
+	}
+
+	m.Batch(func(tx *Tx) {
+		for _, s := range sounds {
+			tx.SetVolume(s, 0.1)
+		}
+		tx.SetPaused(sounds[2], true)
+		tx.SetVolume(stopped, 1)
+		tx.SetPaused(foreign, false)
+		if sounds[0].Volume() != 0.5 {
+			t.Error("changes should only apply after the batch")
+		}
+	})
+	if foreign.Paused() != true {
+		t.Error("sound of another mixer should not be changed")
+	}
+
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	for i := range p {
+		if d := p[i] - 0.2*full; d < -0.0001 || d > 0.0001 {
+			t.Fatalf("at %d got %v want %v", i, p[i], 0.2*full)
+		}
+	}
+}
+
+func TestBatchRecordsAllSoundChanges(t *testing.T) {
+	m := New()
+	err := m.InitOffline(&Options{ChannelCount: 1, Smoothing: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(44100))
+	scheduled := source.PlayOnce()
+	stopped := source.PlayOnce()
+	fading := source.PlayOnce()
+	grouped := source.PlayPaused()
+	looping := newMixerSource(t, m, constantWave(10)).PlayForeverLooping()
+	g, err := m.NewGroup("batch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Batch(func(tx *Tx) {
+		tx.SetVolumeAt(scheduled, 0.5, 20)
+		tx.SetPanAt(scheduled, 0.5, 20)
+		tx.SetPausedAt(scheduled, true, 40)
+		tx.StopAt(stopped, 30)
+		tx.FadeOutAndStop(fading, time.Millisecond)
+		tx.SetGroup(grouped, g)
+		tx.ReleaseLoop(looping)
+	})
+	if scheduled.Volume() != 0.5 || scheduled.Pan() != 0.5 {
+		t.Error("scheduled volume and pan are", scheduled.Volume(), scheduled.Pan())
+	}
+	if !fading.Fading() {
+		t.Error("sound should be fading")
+	}
+	if grouped.Group() != g {
+		t.Error("sound should be in the group")
+	}
+
+	p := make([]float32, 100)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	if !scheduled.Paused() {
+		t.Error("sound should be paused")
+	}
+	if !stopped.Stopped() {
+		t.Error("sound should be stopped")
+	}
+	if !fading.Stopped() {
+		t.Error("sound should be faded out and stopped")
+	}
+	if !looping.Stopped() {
+		t.Error("released loop should have finished")
+	}
+}
+
+func TestSoundFunctionsDoNotWaitForMixing(t *testing.T) {
+	m := New()
+	err := m.InitOffline(&Options{ChannelCount: 1, Smoothing: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(t, m, constantWave(44100))
+	sound := source.PlayOnce()
+
+	// holding the lock is what the mixer does while mixing
+	m.lock.Lock()
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		sound.SetVolume(0.5)
+		sound.SetPan(0.5)
+		sound.SetPosition(500 * time.Millisecond)
+		m.Batch(func(tx *Tx) { tx.SetPitch(sound, 2) })
+		m.SetVolume(0.5)
+		if sound.Volume() != 0.5 || sound.Pitch() != 2 {
+			t.Error("getters should return the changes right away")
+		}
+		if sound.Position() != 500*time.Millisecond {
+			t.Error("position should be set right away")
+		}
+		m.Now()
+		source.PlayOnce().Stop()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sound functions wait for the mixer")
+	}
+	m.lock.Unlock()
+
+	p := make([]float32, 10)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	// the pan of 0.5 halves the left channel, which is mixed to mono
+	want := full * 0.5 * 0.5 * 0.75
+	if d := p[9] - want; d < -0.0001 || d > 0.0001 {
+		t.Errorf("changes were not applied, got %v want %v", p[9], want)
+	}
+}
+
+// benchmarkWhileMixing measures calls to f for a sound while 100 sounds are
+// mixed in another Go routine. Besides the average, it reports the longest
+// call. If f waited for the mixer, the calls would take as long as a mix on
+// average and the longest call could be delayed by many mixes.
+func benchmarkWhileMixing(b *testing.B, f func(s Sound)) {
+	m := New()
+	err := m.InitOffline(&Options{Interpolation: Sinc})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer m.Close()
+
+	source := newMixerSource(b, m, constantWave(44100))
+	source.SetPitch(1.5)
+	for i := 0; i < 100; i++ {
+		source.PlayForeverLooping()
+	}
+	sound := source.PlayForeverLooping()
+
+	stop := make(chan bool)
+	mixing := make(chan bool)
+	go func() {
+		defer close(mixing)
+		p := make([]float32, 2*4410)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.Read(p)
+			}
+		}
+	}()
+
+	var longest time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		f(sound)
+		if d := time.Since(start); d > longest {
+			longest = d
+		}
+	}
+	b.StopTimer()
+	close(stop)
+	<-mixing
+
+	b.ReportMetric(float64(longest.Nanoseconds()), "max-ns/op")
+}
+
+func BenchmarkSetVolumeWhileMixing(b *testing.B) {
+	benchmarkWhileMixing(b, func(s Sound) { s.SetVolume(0.5) })
+}
+
+func BenchmarkPositionWhileMixing(b *testing.B) {
+	benchmarkWhileMixing(b, func(s Sound) { s.Position() })
+}
+
+// TestConcurrentUse calls all the functions of Sounds and SoundSources from
+// several Go routines while the mixer is updated. Run it with -race.
+func TestConcurrentUse(t *testing.T) {
+	m := New()
+	b := NewNullBackend()
+	if err := m.Init(b, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	source := newMixerSource(t, m, rampWave(4410))
+	music, err := m.NewGroup("music", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan bool)
+
+	// the backend drives the updates, like a sound card would
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				b.Advance(10 * time.Millisecond)
+			}
+		}
+	}()
+
+	var soundsLock sync.Mutex
+	var sounds []Sound
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				source.SetVolume(0.5)
+				source.SetPan(-0.5)
+				source.SetPitch(1.5)
+				source.SetLoopRegion(1000, 3000)
+				source.SetLoopCrossfade(time.Millisecond)
+				source.Volume()
+				source.Pan()
+				source.Pitch()
+				source.LoopRegion()
+				source.LoopCrossfade()
+
+				s := source.PlayLooping(3)
+				if g == 0 {
+					s = source.PlayAt(m.Now() + 100)
+				}
+				s.SetVolume(0.8)
+				s.SetPan(0.5)
+				s.SetPitch(2)
+				s.SetPosition(10 * time.Millisecond)
+				s.FadeTo(0.1, 20*time.Millisecond, ExponentialCurve)
+				s.SetVolumeAt(1, m.Now()+200)
+				s.SetPanAt(0, m.Now()+200)
+				s.SetPausedAt(true, m.Now()+300)
+				s.OnFinished(func() {})
+				s.SetGroup(music)
+				music.SetVolume(0.5)
+				music.SetPan(0.5)
+				music.SetMuted(i%2 == 0)
+				music.SetSolo(i%3 == 0)
+				music.SetPaused(i%5 == 0)
+				music.Volume()
+				music.Paused()
+				s.Group()
+				s.Paused()
+				s.Playing()
+				s.Stopped()
+				s.Volume()
+				s.Pan()
+				s.Pitch()
+				s.Length()
+				s.Position()
+				s.Fading()
+				m.Batch(func(tx *Tx) {
+					tx.SetPaused(s, false)
+					tx.SetPosition(s, 0)
+				})
+				m.SetVolume(0.9)
+				m.Clock()
+				m.Latency()
+				m.Stats()
+				switch i % 4 {
+				case 0:
+					s.ReleaseLoop()
+				case 1:
+					s.Stop()
+				case 2:
+					s.FadeOutAndStop(5 * time.Millisecond)
+				case 3:
+					source.StopAll()
+				}
+
+				soundsLock.Lock()
+				sounds = append(sounds, s)
+				soundsLock.Unlock()
+			}
+		}(g)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	m.Close()
+
+	if len(sounds) == 0 {
+		t.Fatal("no sounds were played")
+	}
+	for _, s := range sounds {
+		if !s.Stopped() {
+			t.Fatal("all sounds should be stopped after Close")
+		}
+		select {
+		case <-s.Done():
+		default:
+			t.Fatal("Done should be closed after Close")
+		}
+	}
+}
+
+func TestGroupsScaleTheirSounds(t *testing.T) {
+	m := New()
+	err := m.InitOffline(&Options{ChannelCount: 1, Smoothing: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	music, err := m.NewGroup("music", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ambience, err := m.NewGroup("ambience", music)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sfx, err := m.NewGroup("sfx", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Group("ambience") != ambience || ambience.Parent() != music {
+		t.Fatal("groups should be found by name and know their parent")
+	}
+
+	source := newMixerSource(t, m, constantWave(44100))
+	source.SetGroup(ambience)
+	wind := source.PlayOnce()
+	source.SetGroup(nil)
+	ui := source.PlayPaused()
+	if wind.Group() != ambience || ui.Group() != nil {
+		t.Fatal("sounds should be in the group of their source")
+	}
+
+	p := make([]float32, 10)
+	expect := func(want float32, msg string) {
+		t.Helper()
+		if _, err := m.Read(p); err != nil {
+			t.Fatal(err)
+		}
+		if d := p[9] - want; d < -0.0001 || d > 0.0001 {
+			t.Errorf("%s: got %v want %v", msg, p[9], want)
+		}
+	}
+
+	music.SetVolume(0.5)
+	ambience.SetVolume(0.5)
+	expect(0.25*full, "volumes of nested groups should multiply")
+
+	music.SetPan(1)
+	expect(0.125*full, "group pan should silence the left channel")
+	music.SetPan(0)
+
+	music.SetMuted(true)
+	expect(0, "muted group should be silent")
+	if !music.Muted() || ambience.Muted() {
+		t.Error("mute should only be set on the muted group")
+	}
+	music.SetMuted(false)
+
+	ui.SetPaused(false)
+	sfx.SetSolo(true)
+	expect(0, "solo should silence other groups and sounds without group")
+	ui.SetGroup(sfx)
+	expect(full, "soloed group should be heard")
+	sfx.SetSolo(false)
+	expect(1.25*full, "all sounds should be heard without solo")
+
+	ambience.SetSolo(true)
+	expect(0.25*full, "subgroup can be soloed")
+}
+
+func TestPausedGroupFreezesItsSounds(t *testing.T) {
+	m := New()
+	err := m.InitOffline(&Options{ChannelCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	game, err := m.NewGroup("game", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sfx, err := m.NewGroup("sfx", game)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := newMixerSource(t, m, constantWave(44100))
+	sound := source.PlayOnce()
+	sound.SetGroup(sfx)
+	paused := source.PlayPaused()
+	paused.SetGroup(sfx)
+
+	if _, err := m.Render(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	game.SetPaused(true)
+	if sound.Paused() || sound.Playing() {
+		t.Error("sound should not be paused itself but should not be playing")
+	}
+	wave, err := m.Render(100 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range wave.Data {
+		if wave.Data[i] != 0 {
+			t.Fatal("paused group should be silent")
+		}
+	}
+	if pos := sound.Position(); pos != 100*time.Millisecond {
+		t.Error("sound should not advance while its group is paused, it is at", pos)
+	}
+
+	game.SetPaused(false)
+	if _, err := m.Render(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if pos := sound.Position(); pos != 200*time.Millisecond {
+		t.Error("sound should continue after the group is resumed, it is at", pos)
+	}
+	if !paused.Paused() || paused.Position() != 0 {
+		t.Error("paused sound should stay paused when the group is resumed")
+	}
+}
+
+func TestGroupNamesAreUnique(t *testing.T) {
+	m := New()
+	if _, err := m.NewGroup("music", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.NewGroup("music", nil); err == nil {
+		t.Error("duplicate group name should be an error")
+	}
+	if _, err := m.NewGroup("", nil); err == nil {
+		t.Error("empty group name should be an error")
+	}
+	other, err := New().NewGroup("other", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.NewGroup("child", other); err == nil {
+		t.Error("parent of another mixer should be an error")
+	}
+	if m.Group("unknown") != nil {
+		t.Error("unknown group should be nil")
+	}
+}
+
+func TestAdvanceAndCloseConcurrently(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		m := New()
+		b := NewNullBackend()
+		if err := m.Init(b, nil); err != nil {
+			t.Fatal(err)
+		}
+		source := newMixerSource(t, m, constantWave(44100))
+		source.PlayForeverLooping()
+
+		stop := make(chan bool)
+		done := make(chan bool)
+		go func() {
+			defer close(done)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					b.Advance(10 * time.Millisecond)
+				}
+			}
+		}()
+		time.Sleep(time.Millisecond)
+		m.Close()
+		close(stop)
+		<-done
+	}
+}
+
+func TestKeptSoundsContinueTheirChanges(t *testing.T) {
+	m := New()
+	opts := &Options{ChannelCount: 1, Smoothing: -1, ClosePolicy: KeepSounds}
+	if err := m.InitOffline(opts); err != nil {
+		t.Fatal(err)
+	}
+	source := newMixerSource(t, m, constantWave(44100))
+	fading := source.PlayOnce()
+	fading.FadeTo(0, 200*time.Millisecond, LinearCurve)
+	scheduled := source.PlayAt(m.Now() + 8820)
+	if _, err := m.Render(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	if err := m.InitOffline(opts); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	p := make([]float32, 1)
+	if _, err := m.Read(p); err != nil {
+		t.Fatal(err)
+	}
+	if want := 0.5 * full; p[0] < want-0.001 || p[0] > want+0.001 {
+		t.Errorf("fade should continue at half volume but is at %v", p[0]/full)
+	}
+	if !fading.Fading() {
+		t.Error("fade should still be in progress")
+	}
+	if _, err := m.Render(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if fading.Fading() {
+		t.Error("fade should be over")
+	}
+	if scheduled.Paused() {
+		t.Error("scheduled sound should have started")
+	}
+}
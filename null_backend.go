@@ -0,0 +1,195 @@
+package mixer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gonutz/mixer/wav"
+)
+
+// manualBackend is implemented by backends that drive the mixer updates
+// themselves. Init does not start an update Go routine for them.
+type manualBackend interface {
+	setUpdate(update func() bool, interval time.Duration)
+}
+
+// NullBackend is a Backend that does not output any sound to a device. Its
+// clock does not run in real time, instead it only advances when you call
+// Advance. This makes the mixer deterministic, e.g. for unit tests. All sound
+// data that was played is kept and can be retrieved with Output, so the
+// memory use grows with the played time until you call ClearOutput.
+//
+// Since the NullBackend drives the mixer updates itself, Init does not start a
+// Go routine when using it.
+type NullBackend struct {
+	lock   sync.Mutex
+	format Format
+	buffer []byte
+	cursor uint
+	output []byte
+	update func() bool
+	// interval is the mixer's update interval
+	interval time.Duration
+}
+
+// NewNullBackend creates a NullBackend, pass it to Init to use it.
+func NewNullBackend() *NullBackend {
+	return &NullBackend{}
+}
+
+// Advance moves the clock forward by d. The time is advanced in steps of the
+// mixer's update interval, after every step the mixer is updated just like
+// it would be with a real device. Like with a real device, the mixer advances
+// all sounds by the time since the last update before mixing new data, so a
+// sound that is started between two steps is heard without its first step.
+func (b *NullBackend) Advance(d time.Duration) {
+	b.lock.Lock()
+	format, interval := b.format, b.interval
+	b.lock.Unlock()
+
+	frameSize := format.FrameSize()
+	if frameSize == 0 || interval == 0 {
+		return
+	}
+	byteCount := durationToFrames(d, format.SamplesPerSecond) * frameSize
+	stepSize := durationToFrames(interval, format.SamplesPerSecond) * frameSize
+
+	for byteCount > 0 {
+		n := stepSize
+		if n > byteCount {
+			n = byteCount
+		}
+		if !b.play(n) {
+			return
+		}
+		byteCount -= n
+	}
+}
+
+// Stall moves the clock forward by d in a single step, as if the mixer's Go
+// routine was not running for that time. If d is longer than the mixer's
+// write-ahead, this causes an underrun.
+func (b *NullBackend) Stall(d time.Duration) {
+	b.lock.Lock()
+	format := b.format
+	b.lock.Unlock()
+
+	if byteCount := durationToFrames(d, format.SamplesPerSecond) * format.FrameSize(); byteCount > 0 {
+		b.play(byteCount)
+	}
+}
+
+// play consumes n bytes of the ring buffer and updates the mixer. It returns
+// false if the backend is not open or the mixer failed.
+func (b *NullBackend) play(n int) bool {
+	b.lock.Lock()
+	if len(b.buffer) == 0 {
+		b.lock.Unlock()
+		return false
+	}
+	for n > 0 {
+		// the played data wraps around at the end of the ring buffer
+		chunk := b.buffer[b.cursor:]
+		if len(chunk) > n {
+			chunk = chunk[:n]
+		}
+		b.output = append(b.output, chunk...)
+		b.cursor = (b.cursor + uint(len(chunk))) % uint(len(b.buffer))
+		n -= len(chunk)
+	}
+	update := b.update
+	b.lock.Unlock()
+
+	if update != nil {
+		return update()
+	}
+	return true
+}
+
+// Output returns all sound data that was played since the backend was opened.
+// For the Float32 sample format, the Data contains IEEE floating point numbers
+// instead of integer PCM values.
+func (b *NullBackend) Output() *wav.Wave {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return &wav.Wave{
+		ChannelCount:     b.format.ChannelCount,
+		SamplesPerSecond: b.format.SamplesPerSecond,
+		BitsPerSample:    b.format.SampleFormat.BitsPerSample(),
+		Data:             append([]byte(nil), b.output...),
+	}
+}
+
+// ClearOutput discards the sound data that was played so far. Output then
+// only returns the data that is played afterwards.
+func (b *NullBackend) ClearOutput() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.output = nil
+}
+
+func (b *NullBackend) setUpdate(f func() bool, interval time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.update = f
+	b.interval = interval
+}
+
+// Open is part of the Backend interface.
+func (b *NullBackend) Open(f Format, bufferSize uint) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.format = f
+	b.buffer = make([]byte, bufferSize)
+	b.cursor = 0
+	b.output = nil
+	return nil
+}
+
+// BufferSize is part of the Backend interface.
+func (b *NullBackend) BufferSize() uint {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return uint(len(b.buffer))
+}
+
+// Positions is part of the Backend interface. There is no latency in a
+// NullBackend, the play and write cursors are always the same.
+func (b *NullBackend) Positions() (play, write uint, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.cursor, b.cursor, nil
+}
+
+// Write is part of the Backend interface.
+func (b *NullBackend) Write(data []byte, offset uint) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.buffer) == 0 {
+		return errors.New("mixer.NullBackend.Write: backend is not open")
+	}
+	offset %= uint(len(b.buffer))
+	n := copy(b.buffer[offset:], data)
+	copy(b.buffer, data[n:])
+	return nil
+}
+
+// Start is part of the Backend interface.
+func (b *NullBackend) Start() error {
+	return nil
+}
+
+// Stop is part of the Backend interface.
+func (b *NullBackend) Stop() error {
+	return nil
+}
+
+// Close is part of the Backend interface. The Output is kept after closing.
+func (b *NullBackend) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.buffer = nil
+	b.update = nil
+	return nil
+}
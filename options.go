@@ -0,0 +1,221 @@
+package mixer
+
+import (
+	"fmt"
+	"time"
+)
+
+// Options configure the output of the mixer. The zero value of each field
+// selects its default. Pass nil to Init to use all defaults.
+type Options struct {
+	// SamplesPerSecond is the output sample rate. It must be one of 22050,
+	// 44100, 48000 or 96000. The default is 44100.
+	SamplesPerSecond int
+
+	// ChannelCount is the number of output channels, 1 for mono or 2 for
+	// stereo. The default is 2. In mono output, the left and right channels
+	// of all sounds are averaged.
+	ChannelCount int
+
+	// SampleFormat is the data type of a single output sample. The default is
+	// Int16.
+	SampleFormat SampleFormat
+
+	// WriteAhead is the duration of sound data that is mixed in advance in
+	// every update. If the mixer's Go routine is stalled for longer than this,
+	// the output has gaps. The default is 100ms. It must be greater than the
+	// UpdateInterval.
+	WriteAhead time.Duration
+
+	// UpdateInterval is the longest time before changes to sounds are applied
+	// in a mixer update, so this determines how fast the mixer reacts.
+	// Without changes, the mixer only updates when the backend needs data.
+	// The default is 10ms.
+	UpdateInterval time.Duration
+
+	// BufferDuration is the size of the backend's ring buffer. The default is
+	// 2 seconds. It must be at least twice the WriteAhead.
+	BufferDuration time.Duration
+
+	// Recovery is the policy for handling backend errors. If it is nil, the
+	// mixer stops on the first error.
+	Recovery *Recovery
+
+	// ClosePolicy determines whether the sounds are stopped or kept when the
+	// mixer is closed. The default is ReleaseSounds.
+	ClosePolicy ClosePolicy
+
+	// Interpolation is the method for resampling sounds whose sample rate
+	// differs from the output or that are played at a different pitch. The
+	// default is Linear.
+	Interpolation Interpolation
+
+	// Smoothing is the time over which changes of the master volume and of
+	// the volume and pan of playing sounds are ramped, starting at the point
+	// in the output where the change was made. This avoids clicks and zipper
+	// noise. The default is 5ms, a negative value disables smoothing.
+	Smoothing time.Duration
+}
+
+// config is the validated mixer configuration with all defaults filled in.
+type config struct {
+	format         Format
+	writeAhead     time.Duration
+	updateInterval time.Duration
+	bufferDuration time.Duration
+	recovery       *Recovery
+	closePolicy    ClosePolicy
+	interpolation  Interpolation
+	smoothing      time.Duration
+}
+
+// writeAheadFrames returns the number of samples per channel that are written
+// ahead in every update.
+func (c config) writeAheadFrames() int {
+	return durationToFrames(c.writeAhead, c.format.SamplesPerSecond)
+}
+
+// bufferSize returns the size of the ring buffer in bytes.
+func (c config) bufferSize() uint {
+	frames := durationToFrames(c.bufferDuration, c.format.SamplesPerSecond)
+	return uint(frames * c.format.FrameSize())
+}
+
+// smoothingFrames returns the number of output frames over which parameter
+// changes are ramped.
+func (c config) smoothingFrames() int {
+	if c.smoothing < 0 {
+		return 0
+	}
+	return durationToFrames(c.smoothing, c.format.SamplesPerSecond)
+}
+
+func durationToFrames(d time.Duration, samplesPerSecond int) int {
+	return int(d.Seconds()*float64(samplesPerSecond) + 0.5)
+}
+
+// SampleFormat is the data type of a single sample of one channel.
+type SampleFormat int
+
+const (
+	// Int16 samples are little endian signed 16 bit integers.
+	Int16 SampleFormat = iota
+	// Int24 samples are little endian signed 24 bit integers.
+	Int24
+	// Float32 samples are little endian IEEE 754 32 bit floating point numbers
+	// in the range [-1..1].
+	Float32
+)
+
+// BitsPerSample returns the size of a single sample in bits.
+func (f SampleFormat) BitsPerSample() int {
+	switch f {
+	case Int24:
+		return 24
+	case Float32:
+		return 32
+	default:
+		return 16
+	}
+}
+
+func (f SampleFormat) String() string {
+	switch f {
+	case Int16:
+		return "Int16"
+	case Int24:
+		return "Int24"
+	case Float32:
+		return "Float32"
+	default:
+		return fmt.Sprintf("SampleFormat(%d)", int(f))
+	}
+}
+
+// config validates the options and returns the resulting configuration with
+// all defaults filled in.
+func (o *Options) config() (config, error) {
+	c := config{
+		format: Format{
+			SamplesPerSecond: 44100,
+			ChannelCount:     2,
+			SampleFormat:     Int16,
+		},
+		writeAhead:     100 * time.Millisecond,
+		updateInterval: 10 * time.Millisecond,
+		bufferDuration: 2 * time.Second,
+		smoothing:      5 * time.Millisecond,
+	}
+	if o == nil {
+		return c, nil
+	}
+
+	f := &c.format
+	if o.SamplesPerSecond != 0 {
+		f.SamplesPerSecond = o.SamplesPerSecond
+	}
+	if o.ChannelCount != 0 {
+		f.ChannelCount = o.ChannelCount
+	}
+	f.SampleFormat = o.SampleFormat
+	if o.WriteAhead != 0 {
+		c.writeAhead = o.WriteAhead
+	}
+	if o.UpdateInterval != 0 {
+		c.updateInterval = o.UpdateInterval
+	}
+	if o.BufferDuration != 0 {
+		c.bufferDuration = o.BufferDuration
+	}
+	if o.Recovery != nil {
+		c.recovery = o.Recovery.withDefaults()
+	}
+	c.closePolicy = o.ClosePolicy
+	c.interpolation = o.Interpolation
+	if o.Smoothing != 0 {
+		c.smoothing = o.Smoothing
+	}
+
+	switch f.SamplesPerSecond {
+	case 22050, 44100, 48000, 96000:
+	default:
+		return c, fmt.Errorf(
+			"mixer: unsupported sample rate %v, must be 22050, 44100, 48000 or 96000",
+			f.SamplesPerSecond)
+	}
+	if !(f.ChannelCount == 1 || f.ChannelCount == 2) {
+		return c, fmt.Errorf(
+			"mixer: unsupported channel count %v, must be 1 or 2", f.ChannelCount)
+	}
+	if !(f.SampleFormat == Int16 ||
+		f.SampleFormat == Int24 ||
+		f.SampleFormat == Float32) {
+		return c, fmt.Errorf("mixer: unsupported sample format %v", f.SampleFormat)
+	}
+	if !(c.closePolicy == ReleaseSounds || c.closePolicy == KeepSounds) {
+		return c, fmt.Errorf("mixer: unsupported close policy %v", c.closePolicy)
+	}
+	switch c.interpolation {
+	case Linear, Nearest, Cubic, Sinc:
+	default:
+		return c, fmt.Errorf(
+			"mixer: unsupported interpolation %v", c.interpolation)
+	}
+	if c.updateInterval < time.Millisecond {
+		return c, fmt.Errorf(
+			"mixer: update interval %v is too small, must be at least 1ms",
+			c.updateInterval)
+	}
+	if c.writeAhead <= c.updateInterval {
+		return c, fmt.Errorf(
+			"mixer: write-ahead %v must be greater than the update interval %v",
+			c.writeAhead, c.updateInterval)
+	}
+	if c.bufferDuration < 2*c.writeAhead {
+		return c, fmt.Errorf(
+			"mixer: buffer duration %v must be at least twice the write-ahead %v",
+			c.bufferDuration, c.writeAhead)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,78 @@
+package mixer
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// command is a change that an API function posts to the mixer. The mixer
+// applies it with its lock held, before it mixes the next data.
+type command struct {
+	apply func()
+	next  *command
+}
+
+// commandQueue is a lock-free queue of commands. Any number of Go routines may
+// push commands at the same time, only the mixer takes them out. This way the
+// API functions never wait for the mixer to finish mixing.
+//
+// The commands are kept in a linked list with the newest command at the head.
+// The mixer takes the whole list at once and reverses it.
+type commandQueue struct {
+	// head is the *command that was pushed last, nil if the queue is empty
+	head unsafe.Pointer
+}
+
+// push adds a command that calls f to the queue.
+func (q *commandQueue) push(f func()) {
+	c := &command{apply: f}
+	for {
+		head := atomic.LoadPointer(&q.head)
+		c.next = (*command)(head)
+		if atomic.CompareAndSwapPointer(&q.head, head, unsafe.Pointer(c)) {
+			return
+		}
+	}
+}
+
+// takeAll empties the queue and returns its commands in the order in which
+// they were pushed.
+func (q *commandQueue) takeAll() *command {
+	c := (*command)(atomic.SwapPointer(&q.head, nil))
+	var first *command
+	for c != nil {
+		next := c.next
+		c.next = first
+		first = c
+		c = next
+	}
+	return first
+}
+
+// post queues f to be applied by the mixer before it mixes the next data. If
+// the mixer is not running, nothing is mixed and f is applied right away.
+func (m *Mixer) post(f func()) {
+	m.commands.push(f)
+	if atomic.LoadInt32(&m.mixing) == 0 {
+		m.lock.Lock()
+		m.publish()
+		m.lock.Unlock()
+		return
+	}
+	select {
+	case m.changed <- struct{}{}:
+	default:
+		// the update Go routine was already woken up
+	}
+}
+
+// applyCommands applies all posted commands. It returns true if there were
+// any. It must be called with the mixer locked.
+func (m *Mixer) applyCommands() bool {
+	c := m.commands.takeAll()
+	applied := c != nil
+	for ; c != nil; c = c.next {
+		c.apply()
+	}
+	return applied
+}
@@ -0,0 +1,147 @@
+package mixer
+
+import "sort"
+
+// ramp is a parameter that changes from one value to another along a Curve
+// over a number of output frames. Parameters are ramped instead of changed
+// right away to avoid clicks and zipper noise in the output.
+//
+// A ramp is a timeline of changes, each starting at an output frame of the
+// mixer clock. Changes are either scheduled at a given frame or start with the
+// next mix. Since every update mixes the whole write-ahead again, the ramp is
+// not changed while mixing. Before mixing, start resolves the new changes and
+// drops the ones that are over, then the values are read with at.
+type ramp struct {
+	// value is the value before the first change
+	value   float32
+	changes []rampChange
+}
+
+type rampChange struct {
+	from, to float32
+	// start is the output frame at which the change starts, if next is true
+	// it is set to the start of the next mix
+	start  int64
+	next   bool
+	length int
+	curve  Curve
+}
+
+// constantRamp returns a ramp that stays at v.
+func constantRamp(v float32) ramp {
+	return ramp{value: v}
+}
+
+// set changes the value linearly to v over the given number of frames,
+// starting with the next mix.
+func (r *ramp) set(v float32, frames int) {
+	r.setCurve(v, frames, LinearCurve)
+}
+
+// setCurve changes the value to v over the given number of frames along the
+// given curve, starting with the next mix.
+func (r *ramp) setCurve(v float32, frames int, curve Curve) {
+	r.changes = append(r.changes, rampChange{
+		to:     v,
+		next:   true,
+		length: frames,
+		curve:  curve,
+	})
+}
+
+// setAt changes the value to v over the given number of frames along the
+// given curve, starting at the given output frame.
+func (r *ramp) setAt(v float32, frames int, curve Curve, start int64) {
+	r.changes = append(r.changes, rampChange{
+		to:     v,
+		start:  start,
+		length: frames,
+		curve:  curve,
+	})
+}
+
+// start prepares the ramp for mixing output frames starting at frame.
+func (r *ramp) start(frame int64) {
+	if len(r.changes) == 0 {
+		return
+	}
+
+	// changes that were made since the last mix start now, the frames before
+	// were mixed without them
+	for i := range r.changes {
+		if c := &r.changes[i]; c.next {
+			c.start = frame
+			c.next = false
+		}
+	}
+	sort.SliceStable(r.changes, func(i, j int) bool {
+		return r.changes[i].start < r.changes[j].start
+	})
+	for i := range r.changes {
+		c := &r.changes[i]
+		c.from = valueAt(r.value, r.changes[:i], c.start)
+	}
+
+	// only the last change that started is relevant from now on
+	last := -1
+	for i := range r.changes {
+		if r.changes[i].start <= frame {
+			last = i
+		}
+	}
+	if last >= 0 {
+		c := r.changes[last]
+		if c.start+int64(c.length) <= frame {
+			r.value = c.to
+			last++
+		} else {
+			r.value = c.from
+		}
+		r.changes = r.changes[last:]
+	}
+}
+
+// shift moves the changes that start at a given frame by the given number
+// of frames, e.g. when the mixer clock starts over.
+func (r *ramp) shift(frames int64) {
+	for i := range r.changes {
+		if c := &r.changes[i]; !c.next {
+			c.start += frames
+		}
+	}
+}
+
+// at returns the value at the given output frame.
+func (r *ramp) at(frame int64) float32 {
+	if len(r.changes) == 0 {
+		return r.value
+	}
+	return valueAt(r.value, r.changes, frame)
+}
+
+// done returns true if the ramp does not change after the given frame.
+func (r *ramp) done(frame int64) bool {
+	for _, c := range r.changes {
+		if c.next || c.start+int64(c.length) > frame {
+			return false
+		}
+	}
+	return true
+}
+
+// valueAt returns the value at the given frame for the changes, which are
+// sorted by their start. The value is that of the last change that started.
+func valueAt(value float32, changes []rampChange, frame int64) float32 {
+	for i := range changes {
+		c := &changes[i]
+		if c.start > frame {
+			break
+		}
+		if i := frame - c.start; i < int64(c.length) {
+			value = c.curve.at(c.from, c.to, float64(i)/float64(c.length))
+		} else {
+			value = c.to
+		}
+	}
+	return value
+}
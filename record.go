@@ -0,0 +1,152 @@
+package mixer
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/gonutz/mixer/wav"
+)
+
+// StartRecording starts writing everything that the mixer outputs to w as a
+// WAV file in the mixer's output format. Call StopRecording to complete the
+// file. The data is written in a separate Go routine so slow I/O does not
+// stall the mixer.
+// The mixer must be initialized and not already be recording.
+func (m *Mixer) StartRecording(w io.WriteSeeker) error {
+	m.initLock.Lock()
+	defer m.initLock.Unlock()
+	if m.State() != Running {
+		return errors.New("mixer.StartRecording: mixer is not running")
+	}
+
+	m.lock.Lock()
+	f := m.format
+	recording := m.recorder != nil
+	m.lock.Unlock()
+
+	if recording {
+		return errors.New("mixer.StartRecording: already recording")
+	}
+
+	var writer *wav.Writer
+	var err error
+	if f.SampleFormat == Float32 {
+		writer, err = wav.NewFloatWriter(w, f.ChannelCount, f.SamplesPerSecond)
+	} else {
+		writer, err = wav.NewWriter(
+			w, f.ChannelCount, f.SamplesPerSecond, f.SampleFormat.BitsPerSample())
+	}
+	if err != nil {
+		return err
+	}
+
+	r := &recorder{
+		writer: writer,
+		wake:   make(chan bool, 1),
+		done:   make(chan bool),
+	}
+
+	go r.run()
+	m.lock.Lock()
+	m.recorder = r
+	m.lock.Unlock()
+
+	return nil
+}
+
+// StopRecording blocks until all recorded data is written and fixes up the
+// WAV header. It returns the first error that occurred while writing. The
+// io.WriteSeeker passed to StartRecording is not closed.
+func (m *Mixer) StopRecording() error {
+	m.lock.Lock()
+	r := m.recorder
+	m.recorder = nil
+	m.lock.Unlock()
+
+	if r == nil {
+		return nil
+	}
+	return r.stop()
+}
+
+// record passes the given output data to the recorder, if any. The data is
+// copied so the caller can reuse it.
+func (m *Mixer) record(data []byte) {
+	if m.recorder != nil {
+		m.recorder.add(append([]byte(nil), data...))
+	}
+}
+
+// recordSilence records byteCount bytes of silence, this is used for output
+// that was played but not written by the mixer.
+func (m *Mixer) recordSilence(byteCount int) {
+	if m.recorder != nil && byteCount > 0 {
+		// Float32 and signed integer silence are all zero bytes
+		m.recorder.add(make([]byte, byteCount))
+	}
+}
+
+// recorder queues the data to write and writes it in its own Go routine.
+type recorder struct {
+	writer *wav.Writer
+
+	lock    sync.Mutex
+	queue   [][]byte
+	stopped bool
+	err     error
+
+	// wake signals the Go routine that new data or a stop request is queued
+	wake chan bool
+	// done is closed when the Go routine has written all data
+	done chan bool
+}
+
+func (r *recorder) add(data []byte) {
+	r.lock.Lock()
+	r.queue = append(r.queue, data)
+	r.lock.Unlock()
+	r.signal()
+}
+
+func (r *recorder) signal() {
+	select {
+	case r.wake <- true:
+	default:
+		// the Go routine is already signalled
+	}
+}
+
+func (r *recorder) run() {
+	defer close(r.done)
+	for range r.wake {
+		r.lock.Lock()
+		queue := r.queue
+		r.queue = nil
+		stopped := r.stopped
+		r.lock.Unlock()
+
+		for _, data := range queue {
+			if _, err := r.writer.Write(data); err != nil && r.err == nil {
+				r.err = err
+			}
+		}
+
+		if stopped {
+			return
+		}
+	}
+}
+
+func (r *recorder) stop() error {
+	r.lock.Lock()
+	r.stopped = true
+	r.lock.Unlock()
+	r.signal()
+
+	<-r.done
+	if err := r.writer.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
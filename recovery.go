@@ -0,0 +1,149 @@
+package mixer
+
+import "time"
+
+// Recovery is a policy for handling errors of the backend. Without a Recovery
+// policy, the mixer stops on the first error and you have to poll Error to
+// find out about it.
+//
+// With a Recovery policy, a failed update is first retried in the next
+// updates. If it keeps failing, the backend is closed and re-opened, waiting
+// longer between each attempt. After a successful re-open, all sounds resume
+// at the positions where they were when the error occurred.
+//
+// Recovery only applies to the mixer's update Go routine, backends that drive
+// the updates themselves, like the NullBackend, are not recovered.
+type Recovery struct {
+	// Retries is the number of consecutive failed updates after which the
+	// backend is re-opened.
+	Retries int
+
+	// Backoff is the time to wait before the first attempt to re-open the
+	// backend. It is doubled after every failed attempt, up to MaxBackoff. The
+	// defaults are 100ms and 5 seconds.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// MaxReopens is the number of attempts to re-open the backend before the
+	// mixer gives up and stops. If it is 0, the mixer never gives up.
+	MaxReopens int
+
+	// OnError is called for every backend error. If fatal is true, the mixer
+	// gave up and stopped. It is called in a separate Go routine so it is
+	// safe to call any mixer functions from it.
+	OnError func(err error, fatal bool)
+
+	// OnRecovered is called after the backend was re-opened and the mixer
+	// resumed playing. It is called in a separate Go routine so it is safe to
+	// call any mixer functions from it.
+	OnRecovered func()
+}
+
+// withDefaults returns a copy of r with all defaults filled in.
+func (r Recovery) withDefaults() *Recovery {
+	if r.Backoff <= 0 {
+		r.Backoff = 100 * time.Millisecond
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = 5 * time.Second
+	}
+	if r.MaxBackoff < r.Backoff {
+		r.MaxBackoff = r.Backoff
+	}
+	return &r
+}
+
+func (r *Recovery) notifyError(err error, fatal bool) {
+	if r.OnError != nil {
+		go r.OnError(err, fatal)
+	}
+}
+
+func (r *Recovery) notifyRecovered() {
+	if r.OnRecovered != nil {
+		go r.OnRecovered()
+	}
+}
+
+// recover handles the failed update according to the recovery policy. It is
+// called in the update Go routine, failures is the number of consecutive
+// failed updates. It returns false if the mixer has to stop, either because
+// there is no recovery policy, the policy gave up or stop was signalled.
+func (m *Mixer) recover(stop chan bool, failures int) bool {
+	r := m.recovery
+	if r == nil {
+		return false
+	}
+
+	err := m.Error()
+	if failures <= r.Retries {
+		r.notifyError(err, false)
+		return true
+	}
+
+	m.lock.Lock()
+	m.backend.Stop()
+	m.backend.Close()
+	m.lock.Unlock()
+
+	backoff := r.Backoff
+	for attempt := 1; ; attempt++ {
+		r.notifyError(err, false)
+
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			// the backend is closed already, signal this to Close
+			m.lock.Lock()
+			m.backendClosed = true
+			m.lock.Unlock()
+			return false
+		}
+
+		if err = m.reopen(); err == nil {
+			r.notifyRecovered()
+			return true
+		}
+
+		if r.MaxReopens > 0 && attempt >= r.MaxReopens {
+			r.notifyError(err, true)
+			m.lock.Lock()
+			m.backendClosed = true
+			m.lock.Unlock()
+			return false
+		}
+
+		backoff *= 2
+		if backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+}
+
+// reopen opens the closed backend again and resumes playing where it stopped.
+func (m *Mixer) reopen() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	b := m.backend
+	if err := b.Open(m.format, m.bufferSize); err != nil {
+		m.lastError = err
+		return err
+	}
+	if err := b.Write(m.mix(), 0); err != nil {
+		b.Close()
+		m.lastError = err
+		return err
+	}
+	if err := b.Start(); err != nil {
+		b.Close()
+		m.lastError = err
+		return err
+	}
+
+	m.writeCursor = 0
+	m.sched.reset()
+	m.sched.wrote(len(m.writeAheadBuffer))
+	m.lastError = nil
+	return nil
+}
@@ -0,0 +1,188 @@
+package mixer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/gonutz/mixer/wav"
+)
+
+// InitOffline prepares the mixer for offline rendering. No backend is opened
+// and no Go routine is started. Sounds are played as usual through the
+// SoundSources but their data is only mixed when you call Render, RenderTo or
+// Read.
+// The time in the mixer only advances by the rendered durations, there is no
+// wall-clock timing involved, which makes the output deterministic.
+// The output format is configured with the given Options, pass nil to use the
+// defaults. The WriteAhead option determines the size of the chunks that are
+// mixed at once, the other timing options are ignored.
+// Call Close when you are done with the mixer.
+func (m *Mixer) InitOffline(opts *Options) error {
+	m.initLock.Lock()
+	defer m.initLock.Unlock()
+	if done, err := m.checkInit(); done {
+		return err
+	}
+
+	c, err := opts.config()
+	if err != nil {
+		return err
+	}
+
+	m.setup(c)
+	m.lock.Lock()
+	m.offline = true
+	m.changeState(Running)
+	m.lock.Unlock()
+
+	return nil
+}
+
+// Render mixes the next duration d of sound and returns it as a wave in the
+// output format of the mixer. All playing sounds are advanced by d.
+// For the Float32 sample format, the Data contains IEEE floating point numbers
+// instead of integer PCM values.
+// The mixer must have been initialized with InitOffline.
+func (m *Mixer) Render(d time.Duration) (*wav.Wave, error) {
+	var buf bytes.Buffer
+	if err := m.RenderTo(&buf, d); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return &wav.Wave{
+		ChannelCount:     m.format.ChannelCount,
+		SamplesPerSecond: m.format.SamplesPerSecond,
+		BitsPerSample:    m.format.SampleFormat.BitsPerSample(),
+		Data:             buf.Bytes(),
+	}, nil
+}
+
+// RenderTo mixes the next duration d of sound and writes it to w as
+// interleaved PCM data in the output format of the mixer. All playing sounds
+// are advanced by d.
+// The mixer must have been initialized with InitOffline.
+func (m *Mixer) RenderTo(w io.Writer, d time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !m.offline {
+		return errors.New("mixer.RenderTo: mixer was not initialized with InitOffline")
+	}
+	m.applyCommands()
+	defer m.publish()
+
+	byteCount := int(d.Seconds()*float64(m.format.SamplesPerSecond)+0.5) * m.frameSize
+	for byteCount > 0 {
+		data := m.mix()
+		if len(data) > byteCount {
+			data = data[:byteCount]
+		}
+		m.advanceByFrames(len(data) / m.frameSize)
+		m.record(data)
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		byteCount -= len(data)
+	}
+
+	return nil
+}
+
+// Read mixes the next len(p) samples of sound into p. The samples are
+// interleaved for all output channels and in the range [-1..1]. All playing
+// sounds are advanced by exactly the number of samples per channel that were
+// read. If len(p) is not a multiple of the channel count, the last incomplete
+// sample is left untouched. The returned n is the number of values written.
+//
+// Read lets you use the Mixer as a pure software mixer, e.g. to feed an audio
+// library that asks for samples through a callback.
+// The mixer must have been initialized with InitOffline.
+func (m *Mixer) Read(p []float32) (n int, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !m.offline {
+		return 0, errors.New("mixer.Read: mixer was not initialized with InitOffline")
+	}
+	m.applyCommands()
+	defer m.publish()
+
+	channelCount := m.format.ChannelCount
+	frameCount := len(p) / channelCount
+	for frameCount > 0 {
+		frames := frameCount
+		if frames > len(m.leftBuffer) {
+			frames = len(m.leftBuffer)
+		}
+		left, right := m.mixFrames(frames)
+		m.advanceByFrames(frames)
+		if m.recorder != nil {
+			m.record(m.encode(left, right))
+		}
+		for i := range left {
+			if channelCount == 1 {
+				p[n] = clamp((left[i] + right[i]) / 2)
+			} else {
+				p[n] = clamp(left[i])
+				p[n+1] = clamp(right[i])
+			}
+			n += channelCount
+		}
+		frameCount -= frames
+	}
+
+	return n, nil
+}
+
+// PCMReader returns an io.Reader that mixes sound data in the output format
+// of m whenever it is read, just like Read. Each call to Read on it reads as
+// many whole samples as fit into its buffer.
+// The mixer must have been initialized with InitOffline.
+func (m *Mixer) PCMReader() io.Reader {
+	return pcmReader{m}
+}
+
+type pcmReader struct {
+	m *Mixer
+}
+
+func (r pcmReader) Read(p []byte) (n int, err error) {
+	m := r.m
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !m.offline {
+		return 0, errors.New("mixer.PCMReader: mixer was not initialized with InitOffline")
+	}
+	if len(p) < m.frameSize {
+		return 0, io.ErrShortBuffer
+	}
+	m.applyCommands()
+	defer m.publish()
+
+	byteCount := len(p) - len(p)%m.frameSize
+	for n < byteCount {
+		data := m.mix()
+		if len(data) > byteCount-n {
+			data = data[:byteCount-n]
+		}
+		m.advanceByFrames(len(data) / m.frameSize)
+		m.record(data)
+		n += copy(p[n:], data)
+	}
+
+	return n, nil
+}
+
+func clamp(f float32) float32 {
+	if f < -1 {
+		return -1
+	}
+	if f > 1 {
+		return 1
+	}
+	return f
+}
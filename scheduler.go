@@ -0,0 +1,98 @@
+package mixer
+
+import "time"
+
+// Notifier can be implemented by a Backend that is able to signal when it
+// needs new data, e.g. through a device event. The mixer's update Go routine
+// then wakes up on these notifications. It still wakes up by itself if no
+// notification arrives in time, when half the written data was played.
+type Notifier interface {
+	// Notify returns a channel that receives a value whenever the backend has
+	// played enough data that the mixer should update. It is called once
+	// after Start.
+	Notify() <-chan struct{}
+}
+
+// scheduler decides when the update Go routine wakes up next. Instead of
+// polling the backend at a fixed high rate, the mixer sleeps until the data
+// that it wrote ahead of the write cursor is expected to run low. The expected
+// time is based on the consumption rate that is measured in every update, so
+// it adapts to devices whose clock is off.
+type scheduler struct {
+	// now returns the current time, it is time.Now unless a test replaces it
+	now func() time.Time
+	// lastUpdate is the time of the last update in which the backend had
+	// played data, it is zero before the first measurement
+	lastUpdate time.Time
+	// rate is the measured number of bytes per second that the backend plays,
+	// it is 0 if it was not measured yet
+	rate float64
+	// queued is the number of bytes that were written ahead of the write
+	// cursor at the time written
+	queued  int
+	written time.Time
+}
+
+func newScheduler() scheduler {
+	return scheduler{now: time.Now}
+}
+
+// measure adds a measurement of the backend's consumption rate, playedBytes is
+// the number of bytes played since the last measurement. It returns the time
+// since the last measurement, or 0 for the first one.
+func (s *scheduler) measure(playedBytes int) time.Duration {
+	now := s.now()
+	var elapsed time.Duration
+	if !s.lastUpdate.IsZero() {
+		elapsed = now.Sub(s.lastUpdate)
+		if elapsed > 0 {
+			rate := float64(playedBytes) / elapsed.Seconds()
+			if s.rate == 0 {
+				s.rate = rate
+			} else {
+				// smooth out the jitter of single measurements
+				s.rate = 0.9*s.rate + 0.1*rate
+			}
+		}
+	}
+	s.lastUpdate = now
+	return elapsed
+}
+
+// wrote records that the given number of bytes were written ahead of the
+// write cursor.
+func (s *scheduler) wrote(bytes int) {
+	s.queued = bytes
+	s.written = s.now()
+}
+
+// reset discards all measurements, e.g. after the backend was re-opened.
+func (s *scheduler) reset() {
+	*s = scheduler{now: s.now}
+}
+
+// nextWakeup returns the time to sleep until the next update. The mixer wakes
+// up when half of the data that it wrote ahead is played, so an update that
+// is late by up to half the write-ahead does not cause gaps. The time is
+// computed from the data that is still queued, so a late update does not delay
+// the next one.
+func (m *Mixer) nextWakeup() time.Duration {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	nominal := float64(m.format.SamplesPerSecond * m.frameSize)
+	rate := m.sched.rate
+	// ignore measurements that are way off, e.g. after stalls
+	if rate < nominal/2 || rate > nominal*2 {
+		rate = nominal
+	}
+
+	played := m.sched.now().Sub(m.sched.written).Seconds() * rate
+	queued := float64(m.sched.queued) - played
+	reserve := float64(len(m.writeAheadBuffer)) / 2
+	sleep := time.Duration((queued - reserve) / rate * float64(time.Second))
+	if sleep < time.Millisecond {
+		sleep = time.Millisecond
+	}
+	return sleep
+}
@@ -1,6 +1,10 @@
 package mixer
 
-import "time"
+import (
+	"math"
+	"time"
+	"unsafe"
+)
 
 type Sound interface {
 	// SetPaused starts or stops the sound. Note that the sound position is not
@@ -16,21 +20,57 @@ type Sound interface {
 	// could have reached the end and thus is not audible although not paused.
 	Paused() bool
 
-	// Playing returns true if the sound is not paused and has not reached the
-	// end.
+	// Playing returns true if the sound is not paused, its group is not
+	// paused and it has not reached the end.
 	Playing() bool
 
-	// Stopped returns true if the sound has been fully played. This means that
-	// the user cannot use the sound anymore. Set the pointer to nil in this
-	// case so that the Go runtime can free its memory on the next GC.
+	// Stopped returns true if the sound has been fully played or was stopped.
+	// This means that the user cannot use the sound anymore. Set the pointer
+	// to nil in this case so that the Go runtime can free its memory on the
+	// next GC.
 	Stopped() bool
 
+	// Stop ends the sound early and removes it from the mixer. To avoid a
+	// click, a playing sound is faded out over the smoothing time set in the
+	// mixer's Options before it is Stopped. Paused sounds stop right away.
+	Stop()
+
+	// StopAt stops the sound at the given time of the mixer clock, see
+	// Mixer.Now. The fade-out over the smoothing time ends at t.
+	StopAt(t int64)
+
+	// SetPausedAt pauses or resumes the sound at the given time of the mixer
+	// clock, see Mixer.Now. Calling SetPaused cancels it.
+	SetPausedAt(paused bool, t int64)
+
+	// SetVolumeAt changes the volume at the given time of the mixer clock, see
+	// Mixer.Now. The change is ramped over the smoothing time, starting at t.
+	// Volume returns v right away.
+	SetVolumeAt(v float32, t int64)
+
+	// SetPanAt changes the pan at the given time of the mixer clock, see
+	// Mixer.Now. The change is ramped over the smoothing time, starting at t.
+	// Pan returns p right away.
+	SetPanAt(p float32, t int64)
+
+	// Done returns a channel that is closed when the sound is Stopped, either
+	// because it finished playing, it was stopped or the mixer was closed.
+	Done() <-chan struct{}
+
+	// OnFinished sets a function that is called when the sound is Stopped. It
+	// is called in a separate Go routine so it is safe to call any mixer
+	// functions from it, e.g. to play the next sound. If the sound is already
+	// Stopped, f is called right away.
+	OnFinished(f func())
+
 	// SetVolume sets the volume factor for all channels. Its range is [0..1]
 	// and it will be clamped to that range.
 	// Note that the audible difference in loudness between 100% and 50% is the
 	// same as between 50% and 25% and so on. Changing the sound on a
 	// logarithmic scale will sound to the human ear as if you decrease the
 	// sound by equal steps.
+	// While the sound is playing, the change is ramped over the smoothing
+	// time set in the mixer's Options.
 	SetVolume(float32)
 
 	// Volume returns a value in the range of 0 (silent) to 1 (full volume).
@@ -42,6 +82,8 @@ type Sound interface {
 	// A pan of 0 means both speakers' volumes are at 100%, +1 means the left
 	// speaker is silenced.
 	// This value is clamped to [-1..1]
+	// While the sound is playing, the change is ramped over the smoothing
+	// time set in the mixer's Options.
 	SetPan(float32)
 
 	// Pan returns the current pan as a value in the range of -1 (only left
@@ -49,160 +91,693 @@ type Sound interface {
 	// at full volume.
 	Pan() float32
 
-	// Length is the length of the whole sound, it does not consider how far it
-	// is already played or if it loops or not.
+	// SetPitch sets the playback rate of the sound. A pitch of 2 plays the
+	// sound twice as fast and an octave higher, 0.5 plays it at half the speed
+	// and an octave lower. It is clamped to [0.01..100]. Length and Position
+	// are durations in the sound data, at a pitch of 2 the Position advances
+	// twice as fast as real time.
+	SetPitch(float32)
+
+	// Pitch returns the playback rate of the sound, 1 is the original speed.
+	Pitch() float32
+
+	// Length is the length of the whole sound including all loops, it does not
+	// consider how far it is already played. For sounds that loop forever, the
+	// Length is the maximum time.Duration.
 	Length() time.Duration
 
 	// SetPosition sets the time offset into the sound at which it will continue
-	// to play.
+	// to play. For looping sounds, the position counts from the start of the
+	// first loop so this also sets the current loop.
 	SetPosition(time.Duration)
 
 	// Position is the current offset from the start of the sound. It changes
-	// while the sound is played.
+	// while the sound is played. For looping sounds, it includes all loops
+	// played so far. The position is what is currently heard, the data that
+	// the backend has queued but not played yet is not counted. It does not
+	// go back before the last position set with SetPosition though.
 	Position() time.Duration
+
+	// ReleaseLoop makes a looping sound continue past the end of its loop
+	// region instead of starting over, it then plays the rest of the sound
+	// and stops. If the sound is not in its last loop yet, the current loop
+	// is the last one.
+	ReleaseLoop()
+
+	// FadeTo changes the volume to v over the given duration along the given
+	// curve. The fade is computed for every sample while mixing. Calling
+	// SetVolume ends the fade. Volume returns v right away.
+	FadeTo(v float32, d time.Duration, curve Curve)
+
+	// FadeOutAndStop fades the volume to 0 over the given duration along the
+	// ExponentialCurve and stops the sound once the fade is over.
+	FadeOutAndStop(time.Duration)
+
+	// Fading returns true while a fade started with FadeTo or FadeOutAndStop
+	// is in progress.
+	Fading() bool
+
+	// SetGroup mixes the sound through the given Group, nil removes it from
+	// its group. Groups of other mixers are ignored.
+	SetGroup(Group)
+
+	// Group returns the group that the sound is mixed through, nil if it has
+	// none.
+	Group() Group
 }
 
+// foreverLoops is the loop count of sounds that loop forever.
+const foreverLoops = -1
+
 type sound struct {
-	source                        *soundSource
-	cursor                        int
+	// posted is the number of changes that were posted to the mixer, it is
+	// accessed atomically and comes first to be 64-bit aligned on 32-bit
+	// platforms; applied is the number of the last change that was applied
+	posted, applied uint64
+	// snapshot is the *soundView that the getters read, see view
+	snapshot unsafe.Pointer
+
+	mixer *Mixer
+	// source is nil once the sound is Stopped, length and samplesPerSecond
+	// are those of the source data
+	source                   *soundSource
+	length, samplesPerSecond int
+	// cursor is the position in the source's samples, it is fractional if the
+	// source's sample rate differs from the output sample rate
+	cursor float64
+	// loops is the number of times that the sound is played in total, it is
+	// foreverLoops for sounds that loop until they are stopped
+	loops int
+	// loop is the current loop, starting at 0
+	loop int
+	// loopStart and loopEnd are the source samples between which the sound
+	// loops, the first loop plays from 0 to loopEnd, after the last loop the
+	// sound plays from loopEnd to the end
+	loopStart, loopEnd int
+	// crossfade is the number of samples before loopEnd that are faded over
+	// to the samples before loopStart
+	crossfade int
+	// released is true after ReleaseLoop, the sound does not loop anymore
+	released bool
+	// group is the group that the sound is mixed through, nil if it has none
+	group *group
+
 	paused                        bool
 	volume                        float32
+	pitch                         float32
 	pan                           float32
 	leftPanFactor, rightPanFactor float32
+	// gain ramps to the volume, leftPan and rightPan to the pan factors
+	gain, leftPan, rightPan ramp
+	// envelope fades the sound out when it is stopped
+	envelope ramp
+	// pauseAt is the output frame at which paused changes to pauseTo if
+	// pauseScheduled is true
+	pauseAt        int64
+	pauseTo        bool
+	pauseScheduled bool
+	// audible is true once the sound was played, parameter changes are
+	// ramped from then on
+	audible bool
+	// advanced is the number of output frames that the sound was played since
+	// it started or its position was set
+	advanced int64
+	// mixed is the number of output frames from the start of the last mix
+	// that the sound was mixed into without a break, it is 0 until the sound
+	// is mixed after it started or its position was set
+	mixed int
+	// fading is true if the gain ramp is a fade started with FadeTo,
+	// stopAfterFade is true if the sound stops when the fade ends
+	fading, stopAfterFade bool
+	// stopping is true after Stop, the sound is stopped once the envelope
+	// is done
+	stopping bool
+	// faded is true if the sound was faded out and stopped
+	faded bool
+	// done is closed and onFinished is called when the sound is stopped
+	done       chan struct{}
+	onFinished func()
 }
 
 func (s *sound) SetPaused(paused bool) {
-	if s.source == nil {
+	s.change(pausedChange(paused))
+}
+
+func pausedChange(paused bool) change {
+	return change{
+		apply: func(s *sound) { s.setPaused(paused) },
+		expect: func(_ *sound, v *soundView) {
+			v.paused = paused
+			v.frozen = paused || v.group.pausedSetting()
+		},
+	}
+}
+
+func (s *sound) setPaused(paused bool) {
+	s.paused = paused
+	s.pauseScheduled = false
+}
+
+func (s *sound) SetPausedAt(paused bool, t int64) {
+	s.change(pausedAtChange(paused, t))
+}
+
+func pausedAtChange(paused bool, t int64) change {
+	return change{apply: func(s *sound) {
+		s.pauseAt = t
+		s.pauseTo = paused
+		s.pauseScheduled = true
+	}}
+}
+
+func (s *sound) Stop() {
+	s.change(stopChange())
+}
+
+func stopChange() change {
+	return change{
+		apply: func(s *sound) { s.stop() },
+		expect: func(_ *sound, v *soundView) {
+			if v.paused || !v.ramped {
+				v.stopped = true
+			}
+		},
+	}
+}
+
+// stop fades the sound out and stops it, it must be called with the mixer
+// locked.
+func (s *sound) stop() {
+	frames := s.smoothingFrames()
+	if frames == 0 {
+		s.mixer.removeSound(s)
 		return
 	}
+	s.stopping = true
+	s.envelope.set(0, frames)
+}
 
-	lock.Lock()
-	defer lock.Unlock()
+func (s *sound) StopAt(t int64) {
+	s.change(stopAtChange(t))
+}
 
-	s.paused = paused
+func stopAtChange(t int64) change {
+	return change{apply: func(s *sound) {
+		frames := s.mixer.smoothingFrames
+		s.stopping = true
+		s.envelope.setAt(0, frames, LinearCurve, t-int64(frames))
+	}}
+}
+
+func (s *sound) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *sound) OnFinished(f func()) {
+	if s.view().stopped {
+		if f != nil {
+			go f()
+		}
+		return
+	}
+
+	s.mixer.post(func() {
+		if s.source == nil {
+			if f != nil {
+				go f()
+			}
+			return
+		}
+		s.onFinished = f
+	})
+}
+
+// finish marks the sound as Stopped and notifies the listeners. It must be
+// called with the mixer locked, after the sound was removed from the mixer.
+func (s *sound) finish() {
+	s.source = nil
+	s.publish(s.mixer.view(), true)
+	close(s.done)
+	if s.onFinished != nil {
+		go s.onFinished()
+		s.onFinished = nil
+	}
 }
 
 func (s *sound) Paused() bool {
-	return s.paused
+	return s.view().paused
 }
 
 func (s *sound) Playing() bool {
-	return !s.paused && s.source != nil && s.cursor < len(s.source.left)
+	v := s.view()
+	return !v.paused && !v.stopped && !v.over && !v.group.pausedSetting()
 }
 
 func (s *sound) Stopped() bool {
-	return s.source == nil
+	return s.view().stopped
 }
 
 func (s *sound) SetVolume(v float32) {
-	if s.source == nil {
-		return
+	s.change(volumeChange(v))
+}
+
+func volumeChange(volume float32) change {
+	return change{
+		apply: func(s *sound) { s.setVolume(volume) },
+		expect: func(_ *sound, v *soundView) {
+			v.volume = clampVolume(volume)
+			v.fading = false
+		},
 	}
+}
+
+func (s *sound) setVolume(v float32) {
+	v = clampVolume(v)
+	s.volume = v
+	s.fading = false
+	s.stopAfterFade = false
+	s.gain.set(v, s.smoothingFrames())
+}
+
+func (s *sound) SetVolumeAt(volume float32, t int64) {
+	s.change(volumeAtChange(volume, t))
+}
 
+func volumeAtChange(volume float32, t int64) change {
+	volume = clampVolume(volume)
+	return change{
+		apply: func(s *sound) {
+			s.volume = volume
+			s.gain.setAt(volume, s.mixer.smoothingFrames, LinearCurve, t)
+		},
+		expect: func(_ *sound, v *soundView) { v.volume = volume },
+	}
+}
+
+func clampVolume(v float32) float32 {
 	if v < 0 {
-		v = 0
+		return 0
 	}
 	if v > 1 {
-		v = 1
+		return 1
 	}
-
-	lock.Lock()
-	defer lock.Unlock()
-
-	s.volume = v
+	return v
 }
 
 func (s *sound) Volume() float32 {
-	return s.volume
+	return s.view().volume
 }
 
 func (s *sound) SetPan(p float32) {
-	if s.source == nil {
-		return
+	s.change(panChange(p))
+}
+
+func panChange(pan float32) change {
+	return change{
+		apply:  func(s *sound) { s.setPan(pan) },
+		expect: func(_ *sound, v *soundView) { v.pan = clampPan(pan) },
+	}
+}
+
+func (s *sound) setPan(p float32) {
+	p = clampPan(p)
+	left, right := panFactors(p)
+	s.pan = p
+	s.leftPanFactor, s.rightPanFactor = left, right
+	frames := s.smoothingFrames()
+	s.leftPan.set(left, frames)
+	s.rightPan.set(right, frames)
+}
+
+func (s *sound) SetPanAt(pan float32, t int64) {
+	s.change(panAtChange(pan, t))
+}
+
+func panAtChange(pan float32, t int64) change {
+	pan = clampPan(pan)
+	left, right := panFactors(pan)
+	return change{
+		apply: func(s *sound) {
+			s.pan = pan
+			s.leftPanFactor, s.rightPanFactor = left, right
+			frames := s.mixer.smoothingFrames
+			s.leftPan.setAt(left, frames, LinearCurve, t)
+			s.rightPan.setAt(right, frames, LinearCurve, t)
+		},
+		expect: func(_ *sound, v *soundView) { v.pan = pan },
 	}
+}
 
+func clampPan(p float32) float32 {
 	if p < -1 {
-		p = -1
+		return -1
 	}
 	if p > 1 {
-		p = 1
+		return 1
 	}
+	return p
+}
 
-	left, right := float32(1), float32(1)
+// panFactors returns the volume factors of the left and right channel for the
+// pan p.
+func panFactors(p float32) (left, right float32) {
+	left, right = 1, 1
 	if p < 0 {
 		right = 1 + p
 	}
 	if p > 0 {
 		left = 1 - p
 	}
+	return
+}
 
-	lock.Lock()
-	defer lock.Unlock()
-
-	s.pan = p
-	s.leftPanFactor, s.rightPanFactor = left, right
+// smoothingFrames returns the number of frames over which parameter changes
+// are ramped. Changes to a sound that is not audible are applied right away.
+func (s *sound) smoothingFrames() int {
+	if s.audible && !s.paused && !s.group.isPaused() {
+		return s.mixer.smoothingFrames
+	}
+	return 0
 }
 
 func (s *sound) Pan() float32 {
-	return float32(s.pan)
+	return s.view().pan
+}
+
+func (s *sound) SetPitch(p float32) {
+	s.change(pitchChange(p))
+}
+
+func pitchChange(pitch float32) change {
+	return change{
+		apply:  func(s *sound) { s.setPitch(pitch) },
+		expect: func(_ *sound, v *soundView) { v.pitch = clampPitch(pitch) },
+	}
+}
+
+func (s *sound) setPitch(p float32) {
+	s.pitch = clampPitch(p)
+}
+
+func (s *sound) Pitch() float32 {
+	return s.view().pitch
+}
+
+func clampPitch(p float32) float32 {
+	if p < 0.01 {
+		return 0.01
+	}
+	if p > 100 {
+		return 100
+	}
+	return p
 }
 
 func (s *sound) Length() time.Duration {
-	if s.source == nil {
+	v := s.view()
+	if v.stopped {
 		return 0
 	}
-	return s.source.Length() // TODO * loops
+	if s.loops == foreverLoops && !v.released {
+		return time.Duration(math.MaxInt64)
+	}
+	return samplesToDuration(float64(s.totalSamples(v)), s.samplesPerSecond)
 }
 
-func (s *sound) SetPosition(pos time.Duration) {
-	if s.source == nil {
-		return
+// totalSamples returns the number of source samples that the sound plays
+// including all loops. The sound must not loop forever.
+func (s *sound) totalSamples(v *soundView) int {
+	loops := s.loops
+	if v.released {
+		loops = v.loop + 1
 	}
+	return s.length + (loops-1)*s.loopLength()
+}
+
+func (s *sound) SetPosition(pos time.Duration) {
+	s.change(positionChange(pos))
+}
 
-	lock.Lock()
-	defer lock.Unlock()
+func positionChange(pos time.Duration) change {
+	return change{
+		apply: func(s *sound) { s.setPosition(pos) },
+		expect: func(s *sound, v *soundView) {
+			v.pos = math.Floor(pos.Seconds()*float64(s.samplesPerSecond) + 0.5)
+			if v.pos < 0 {
+				v.pos = 0
+			}
+			if s.loops != foreverLoops || v.released {
+				if length := float64(s.totalSamples(v)); v.pos > length {
+					v.pos = length
+				}
+			}
+			v.advanced = 0
+			v.mixed = 0
+		},
+	}
+}
 
-	s.cursor = int(bytesPerSecond*pos.Seconds()/4.0 + 0.5)
-	if s.cursor < 0 {
-		s.cursor = 0
+func (s *sound) setPosition(pos time.Duration) {
+	cursor := math.Floor(pos.Seconds()*float64(s.samplesPerSecond) + 0.5)
+	if cursor < 0 {
+		cursor = 0
+	}
+	// the first loop starts at 0, all others at the loop start
+	lastLoop := s.loops - 1
+	if s.released {
+		lastLoop = s.loop
 	}
-	if s.cursor > len(s.source.left) {
-		s.cursor = len(s.source.left)
+	s.loop = 0
+	if loopLength := float64(s.loopLength()); loopLength > 0 &&
+		cursor >= float64(s.loopEnd) {
+		s.loop = int((cursor - float64(s.loopStart)) / loopLength)
+		if (s.loops != foreverLoops || s.released) && s.loop > lastLoop {
+			s.loop = lastLoop
+		}
+		cursor -= float64(s.loop) * loopLength
 	}
+	if length := float64(s.length); cursor > length {
+		cursor = length
+	}
+	s.cursor = cursor
+	s.advanced = 0
+	s.mixed = 0
 }
 
 func (s *sound) Position() time.Duration {
-	return time.Duration(float64(s.cursor)/bytesPerSecond*4000000000) * time.Nanosecond
+	v := s.view()
+	if v.stopped {
+		return 0
+	}
+	pos := v.pos
+	if clock := v.clock; !v.frozen && clock != nil {
+		// the position is at the backend's write cursor, go back to what is
+		// heard but not further than the sound was played; if the backend
+		// played past the write cursor, go forward but not further than the
+		// sound was mixed
+		delay := clock.audibleDelay()
+		if delay > float64(v.advanced) {
+			delay = float64(v.advanced)
+		}
+		if delay < -float64(v.mixed) {
+			delay = -float64(v.mixed)
+		}
+		pos -= delay * float64(s.samplesPerSecond) * float64(v.pitch) /
+			float64(clock.samplesPerSecond)
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	if s.loops != foreverLoops || v.released {
+		if length := float64(s.totalSamples(v)); pos > length {
+			pos = length
+		}
+	}
+	return samplesToDuration(pos, s.samplesPerSecond)
 }
 
-func (s *sound) advanceBySamples(sampleCount int) {
-	s.cursor += sampleCount
-	if s.cursor > len(s.source.left) {
-		s.cursor = len(s.source.left)
+func (s *sound) SetGroup(g Group) {
+	if own, ok := s.mixer.ownGroup(g); ok {
+		s.change(groupChange(own))
 	}
 }
 
-func (s *sound) addToMixBuffer() {
-	if s.paused {
+func groupChange(own *group) change {
+	return change{
+		apply: func(s *sound) { s.group = own },
+		expect: func(_ *sound, v *soundView) {
+			v.group = own
+			v.frozen = v.paused || own.pausedSetting()
+		},
+	}
+}
+
+func (s *sound) Group() Group {
+	if g := s.view().group; g != nil {
+		return g
+	}
+	return nil
+}
+
+func (s *sound) ReleaseLoop() {
+	s.change(releaseLoopChange())
+}
+
+func releaseLoopChange() change {
+	return change{
+		apply:  func(s *sound) { s.released = true },
+		expect: func(_ *sound, v *soundView) { v.released = true },
+	}
+}
+
+// step returns the number of source samples that one output sample advances
+// the cursor.
+func (s *sound) step() float64 {
+	return float64(s.samplesPerSecond) * float64(s.pitch) /
+		float64(s.mixer.format.SamplesPerSecond)
+}
+
+// advance moves the sound forward by the given number of output frames that
+// were played, starting at the output frame from.
+func (s *sound) advance(from int64, frameCount int) {
+	end := from + int64(frameCount)
+	if s.group.isPaused() {
+		// the sound does not advance, a scheduled pause applies once the
+		// group is resumed
+	} else if s.pauseScheduled && s.pauseAt < end {
+		at := s.pauseAt
+		if at < from {
+			at = from
+		}
+		if !s.paused {
+			s.advanceByFrames(int(at - from))
+		}
+		s.paused = s.pauseTo
+		s.pauseScheduled = false
+		if !s.paused {
+			s.advanceByFrames(int(end - at))
+		}
+	} else if !s.paused {
+		s.advanceByFrames(frameCount)
+	}
+	if s.stopAfterFade && s.gain.done(end) || s.stopping && s.envelope.done(end) {
+		s.faded = true
+	}
+}
+
+func (s *sound) advanceByFrames(frameCount int) {
+	if frameCount <= 0 {
 		return
 	}
+	s.audible = true
+	s.advanced += int64(frameCount)
+	s.cursor += float64(frameCount) * s.step()
+	for s.cursor >= float64(s.loopEnd) && s.wraps(s.loop) {
+		s.cursor -= float64(s.loopLength())
+		s.loop++
+	}
+	if length := float64(s.length); s.cursor > length {
+		s.cursor = length
+	}
+}
+
+// loopLength returns the number of samples in the loop region.
+func (s *sound) loopLength() int {
+	return s.loopEnd - s.loopStart
+}
+
+// wraps returns true if the sound jumps back to the loop start at the end of
+// the given loop.
+func (s *sound) wraps(loop int) bool {
+	return !s.released && s.loopLength() > 0 &&
+		(s.loops == foreverLoops || loop+1 < s.loops)
+}
+
+// startRamps prepares the sound's ramps for mixing output frames starting at
+// the given frame.
+func (s *sound) startRamps(frame int64) {
+	s.gain.start(frame)
+	s.leftPan.start(frame)
+	s.rightPan.start(frame)
+	s.envelope.start(frame)
+}
+
+// addToMixBuffer adds the sound to the mix buffers, which start at the given
+// output frame.
+func (s *sound) addToMixBuffer(leftBuffer, rightBuffer []float32, frame int64) {
+	s.mixed = 0
+	if s.group.isPaused() {
+		return
+	}
+	g := s.group
+	if g == nil {
+		g = s.mixer.ungrouped
+	}
 
-	writeTo := s.cursor + len(leftBuffer)
-	if writeTo > len(s.source.left) {
-		writeTo = len(s.source.left)
+	first, last := 0, len(leftBuffer)
+	if s.pauseScheduled && s.pauseTo != s.paused {
+		at := s.pauseAt - frame
+		if at < 0 {
+			at = 0
+		}
+		if at > int64(last) {
+			at = int64(last)
+		}
+		if s.paused {
+			first = int(at)
+		} else {
+			last = int(at)
+		}
+	} else if s.paused {
+		return
 	}
 
-	leftFactor := s.volume * s.leftPanFactor
-	rightFactor := s.volume * s.rightPanFactor
-	out := 0
-	for i := s.cursor; i < writeTo; i++ {
-		leftBuffer[out] += s.source.left[i] * leftFactor
-		rightBuffer[out] += s.source.right[i] * rightFactor
-		out++
+	length := len(s.source.left)
+	step := s.step()
+	pos := s.cursor
+	loop := s.loop
+	out := first
+	for ; out < last; out++ {
+		// with a high pitch, a step can be longer than the loop
+		for pos >= float64(s.loopEnd) && s.wraps(loop) {
+			pos -= float64(s.loopLength())
+			loop++
+		}
+		i := int(pos)
+		if i >= length {
+			break
+		}
+		l, r := s.sampleAt(pos, loop)
+		f := frame + int64(out)
+		gain := s.gain.at(f) * s.envelope.at(f)
+		leftBuffer[out] += l * gain * s.leftPan.at(f) * g.soundLeft[out]
+		rightBuffer[out] += r * gain * s.rightPan.at(f) * g.soundRight[out]
+		pos += step
+	}
+	if first == 0 {
+		s.mixed = out
 	}
 }
 
+// frame returns the source sample at index i in the given loop. If the sound
+// wraps at the end of the loop, the samples before the loop end are faded
+// over to the samples before the loop start.
+func (s *sound) frame(i, loop int) (left, right float32) {
+	left, right = s.source.left[i], s.source.right[i]
+	fadeStart := s.loopEnd - s.crossfade
+	if s.crossfade > 0 && fadeStart <= i && i < s.loopEnd && s.wraps(loop) {
+		j := i - s.loopLength()
+		t := float32(i-fadeStart+1) / float32(s.crossfade)
+		left += (s.source.left[j] - left) * t
+		right += (s.source.right[j] - right) * t
+	}
+	return
+}
+
 func (s *sound) isOver() bool {
-	// TODO consider loops
-	return s.cursor >= len(s.source.left)
+	return s.faded || s.cursor >= float64(len(s.source.left))
+}
+
+func samplesToDuration(samples float64, samplesPerSecond int) time.Duration {
+	return time.Duration(samples / float64(samplesPerSecond) * float64(time.Second))
 }
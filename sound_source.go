@@ -2,7 +2,9 @@ package mixer
 
 import (
 	"fmt"
+	"sync"
 	"time"
+	"unsafe"
 
 	"github.com/gonutz/mixer/wav"
 )
@@ -14,8 +16,50 @@ type SoundSource interface {
 	// and stopped when it finishes.
 	PlayOnce() Sound
 
-	//PlayLooping(loops int) Sound
-	//PlayForeverLooping() Sound
+	// PlayLooping adds a new sound to the mixer that is played loops times
+	// without a gap between the loops. It is started right away and stopped
+	// when the last loop finishes. If loops is less than 1, the sound is
+	// played once.
+	PlayLooping(loops int) Sound
+	// PlayForeverLooping adds a new sound to the mixer that starts over every
+	// time it reaches the end. It is started right away and never stops by
+	// itself.
+	PlayForeverLooping() Sound
+
+	// PlayAt adds a new one-time sound to the mixer that starts exactly at the
+	// given time of the mixer clock, see Mixer.Now. Until then, the sound is
+	// Paused.
+	PlayAt(t int64) Sound
+
+	// StopAll stops all Sounds that were played from this source, see
+	// Sound.Stop.
+	StopAll()
+
+	// SetLoopRegion sets the part of the sound data that is repeated in
+	// looping sounds played in the future. start and end are sample indices,
+	// end is exclusive. A looping sound plays from the beginning to end, then
+	// repeats the region from start to end and after the last loop plays from
+	// end to the end of the sound data. This way a sound can have an intro
+	// and a tail that are played only once. The region is clamped to the
+	// sound data. If end is not greater than start, the whole sound data is
+	// looped, which is the default.
+	SetLoopRegion(start, end int)
+	LoopRegion() (start, end int)
+
+	// SetLoopCrossfade sets the duration over which the end of the loop
+	// region is faded over to the samples right before the loop start, for
+	// looping sounds played in the future. This hides clicks at the loop seam
+	// if the data was not cut at zero crossings. If there is not enough data
+	// before the loop start, e.g. for the default loop over the whole sound
+	// data, the end is faded over to the start of the loop region instead and
+	// the repeated loops are shorter by the crossfade. The crossfade is
+	// limited to the length of the loop region, or half of it if the start
+	// of the region is used. The default is 0, meaning no crossfade.
+	//
+	// LoopCrossfade returns the crossfade that is used with the current loop
+	// region.
+	SetLoopCrossfade(time.Duration)
+	LoopCrossfade() time.Duration
 
 	// SetVolume sets the default volume for all sounds played in the future.
 	// Changing the Sound's volume will simply overwrite this setting (instead
@@ -30,34 +74,52 @@ type SoundSource interface {
 	SetPan(float32)
 	Pan() float32
 
+	// SetPitch sets the default playback rate for all sounds played in the
+	// future. Changing the Sound's pitch will simply overwrite this setting.
+	// It is clamped to [0.01..100].
+	SetPitch(float32)
+	Pitch() float32
+
+	// SetGroup sets the Group that all sounds played in the future are mixed
+	// through, nil means no group. Groups of other mixers are ignored.
+	SetGroup(Group)
+	Group() Group
+
 	// Length returns the duration of the sound data. Note that a played Sound
 	// may have a different value for its Length function as it considers
 	// looping.
 	Length() time.Duration
 }
 
-// NewSound creates a new sound source from the given wave data and starts
-// playing it right away. You can call SetPlaying(false) on the returned sound
-// if you do not want to play the sound right away.
-func NewSoundSource(w *wav.Wave) (SoundSource, error) {
+// NewSoundSource creates a new sound source from the given wave data. All
+// Sounds played from it are output by m.
+func (m *Mixer) NewSoundSource(w *wav.Wave) (SoundSource, error) {
+	if w.SamplesPerSecond <= 0 {
+		return nil, fmt.Errorf(
+			"mixer.NewSoundSource: illegal sample rate: %v", w.SamplesPerSecond)
+	}
+
 	left, right, err := makeTwoChannelFloats(w)
 	if err != nil {
 		return nil, err
 	}
 
 	source := &soundSource{
-		left:           left,
-		right:          right,
-		volume:         1,
-		pan:            0,
-		leftPanFactor:  1,
-		rightPanFactor: 1,
+		mixer:            m,
+		left:             left,
+		right:            right,
+		samplesPerSecond: w.SamplesPerSecond,
+		loopEnd:          len(left),
+		volume:           1,
+		pitch:            1,
+		pan:              0,
+		leftPanFactor:    1,
+		rightPanFactor:   1,
 	}
 
 	return source, nil
 }
 
-// TODO resample the right frequency
 func makeTwoChannelFloats(w *wav.Wave) (left, right []float32, err error) {
 	if w.ChannelCount == 1 && w.BitsPerSample == 8 {
 		result := make([]float32, len(w.Data))
@@ -108,37 +170,142 @@ func makeTwoChannelFloats(w *wav.Wave) (left, right []float32, err error) {
 }
 
 type soundSource struct {
-	left, right                   []float32
+	mixer       *Mixer
+	left, right []float32
+	// samplesPerSecond is the sample rate of the source data, it is resampled
+	// to the output sample rate while mixing
+	samplesPerSecond int
+	// loopStart and loopEnd are the sample indices of the loop region
+	loopStart, loopEnd int
+	// crossfade is the loop crossfade in samples
+	crossfade int
+
 	volume                        float32
+	pitch                         float32
 	pan                           float32
 	leftPanFactor, rightPanFactor float32
+	group                         *group
+
+	// sounds are the sounds played from the source that were not Stopped
+	// when the last sound was played, they are needed for StopAll
+	sounds []*sound
+
+	// lock is for the settings and sounds of the source, it is never held by
+	// the mixer so it does not wait for mixing; the sample data does not
+	// change and is read without it
+	lock sync.Mutex
 }
 
 func (s *soundSource) PlayOnce() Sound {
-	return s.play(false)
+	return s.play(false, 1)
 }
 
 func (s *soundSource) PlayPaused() Sound {
-	return s.play(true)
+	return s.play(true, 1)
 }
 
-func (s *soundSource) play(paused bool) Sound {
-	sound := &sound{
-		source:         s,
-		paused:         paused,
-		volume:         s.volume,
-		pan:            s.pan,
-		leftPanFactor:  s.leftPanFactor,
-		rightPanFactor: s.rightPanFactor,
+func (s *soundSource) PlayLooping(loops int) Sound {
+	if loops < 1 {
+		loops = 1
 	}
+	return s.play(false, loops)
+}
+
+func (s *soundSource) PlayForeverLooping() Sound {
+	return s.play(false, foreverLoops)
+}
+
+func (s *soundSource) PlayAt(t int64) Sound {
+	sound := s.newSound(true, 1)
+	sound.pauseAt = t
+	sound.pauseScheduled = true
+	return s.add(sound)
+}
+
+func (s *soundSource) play(paused bool, loops int) Sound {
+	return s.add(s.newSound(paused, loops))
+}
 
-	lock.Lock()
-	defer lock.Unlock()
+// newSound creates a sound with the current settings of the source.
+func (s *soundSource) newSound(paused bool, loops int) *sound {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	sounds = append(sounds, sound)
+	view := &soundView{
+		clock:  s.mixer.view(),
+		paused: paused,
+		volume: s.volume,
+		pan:    s.pan,
+		pitch:  s.pitch,
+		group:  s.group,
+	}
+	loopStart, crossfade := s.loopCrossfade()
+	return &sound{
+		snapshot:         unsafe.Pointer(view),
+		mixer:            s.mixer,
+		source:           s,
+		length:           len(s.left),
+		samplesPerSecond: s.samplesPerSecond,
+		loops:            loops,
+		loopStart:        loopStart,
+		loopEnd:          s.loopEnd,
+		crossfade:        crossfade,
+		paused:           paused,
+		volume:           s.volume,
+		pitch:            s.pitch,
+		pan:              s.pan,
+		leftPanFactor:    s.leftPanFactor,
+		rightPanFactor:   s.rightPanFactor,
+		gain:             constantRamp(s.volume),
+		leftPan:          constantRamp(s.leftPanFactor),
+		rightPan:         constantRamp(s.rightPanFactor),
+		envelope:         constantRamp(1),
+		group:            s.group,
+		done:             make(chan struct{}),
+	}
+}
+
+// add adds the new sound to the mixer.
+func (s *soundSource) add(sound *sound) Sound {
+	s.lock.Lock()
+	s.removeStopped()
+	s.sounds = append(s.sounds, sound)
+	s.lock.Unlock()
+
+	m := s.mixer
+	m.post(func() { m.sounds = append(m.sounds, sound) })
 	return sound
 }
 
+// removeStopped removes the Stopped sounds from s.sounds, it must be called
+// with the source locked.
+func (s *soundSource) removeStopped() {
+	n := 0
+	for _, sound := range s.sounds {
+		if !sound.Stopped() {
+			s.sounds[n] = sound
+			n++
+		}
+	}
+	for i := n; i < len(s.sounds); i++ {
+		s.sounds[i] = nil
+	}
+	s.sounds = s.sounds[:n]
+}
+
+func (s *soundSource) StopAll() {
+	s.lock.Lock()
+	s.removeStopped()
+	sounds := append([]*sound(nil), s.sounds...)
+	s.lock.Unlock()
+
+	s.mixer.Batch(func(tx *Tx) {
+		for _, sound := range sounds {
+			tx.Stop(sound)
+		}
+	})
+}
+
 func (s *soundSource) SetVolume(v float32) {
 	if v < 0 {
 		v = 0
@@ -147,37 +314,140 @@ func (s *soundSource) SetVolume(v float32) {
 		v = 1
 	}
 
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	s.volume = v
 }
 
 func (s *soundSource) Volume() float32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	return s.volume
 }
 
 func (s *soundSource) SetPan(p float32) {
-	if p < -1 {
-		p = -1
-	}
-	if p > 1 {
-		p = 1
-	}
+	p = clampPan(p)
 
-	left, right := float32(1), float32(1)
-	if p < 0 {
-		right = 1 + p
-	}
-	if p > 0 {
-		left = 1 - p
-	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
 	s.pan = p
-	s.leftPanFactor, s.rightPanFactor = left, right
+	s.leftPanFactor, s.rightPanFactor = panFactors(p)
 }
 
 func (s *soundSource) Pan() float32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	return s.pan
 }
 
+func (s *soundSource) SetPitch(p float32) {
+	p = clampPitch(p)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pitch = p
+}
+
+func (s *soundSource) Pitch() float32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.pitch
+}
+
+func (s *soundSource) SetGroup(g Group) {
+	own, ok := s.mixer.ownGroup(g)
+	if !ok {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.group = own
+}
+
+func (s *soundSource) Group() Group {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.group == nil {
+		return nil
+	}
+	return s.group
+}
+
 func (s *soundSource) Length() time.Duration {
-	return time.Duration(float64(len(s.left))/bytesPerSecond*4000000000) * time.Nanosecond
+	return samplesToDuration(float64(len(s.left)), s.samplesPerSecond)
+}
+
+func (s *soundSource) SetLoopRegion(start, end int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(s.left) {
+		end = len(s.left)
+	}
+	if end <= start {
+		start, end = 0, len(s.left)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.loopStart, s.loopEnd = start, end
+}
+
+func (s *soundSource) LoopRegion() (start, end int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.loopStart, s.loopEnd
+}
+
+func (s *soundSource) SetLoopCrossfade(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	crossfade := durationToFrames(d, s.samplesPerSecond)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.crossfade = crossfade
+}
+
+func (s *soundSource) LoopCrossfade() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, crossfade := s.loopCrossfade()
+	return samplesToDuration(float64(crossfade), s.samplesPerSecond)
+}
+
+// loopCrossfade returns the start of the loop region and the crossfade in
+// samples that sounds loop with. The crossfade fades to the data before the
+// loop start. If there is not enough of it, e.g. when the whole sound data is
+// looped, it fades to the start of the loop region instead and the repeated
+// loops start after the crossfaded samples. The crossfade is limited to the
+// loop length. It must be called with the source locked.
+func (s *soundSource) loopCrossfade() (start, crossfade int) {
+	start, crossfade = s.loopStart, s.crossfade
+	length := s.loopEnd - s.loopStart
+	if crossfade > length {
+		crossfade = length
+	}
+	if crossfade > s.loopStart {
+		// the faded samples and the samples they fade to must not overlap
+		if crossfade > length/2 {
+			crossfade = length / 2
+		}
+		start += crossfade
+	}
+	return start, crossfade
 }
@@ -0,0 +1,99 @@
+package mixer
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// State is the lifecycle state of a Mixer.
+//
+// A new Mixer is Stopped. Init and InitOffline make it Running. If the mixer
+// stops because of a backend error, it is Failed. While Close is shutting the
+// mixer down it is Closing, after that it is Stopped again and can be
+// re-initialized.
+type State int
+
+const (
+	// Stopped means that the mixer is not initialized or was closed.
+	Stopped State = iota
+	// Running means that the mixer is initialized and outputs sound.
+	Running
+	// Failed means that the mixer stopped because of an error, see
+	// Mixer.Error. Call Close before initializing it again.
+	Failed
+	// Closing means that Close is in progress.
+	Closing
+)
+
+func (s State) String() string {
+	switch s {
+	case Stopped:
+		return "Stopped"
+	case Running:
+		return "Running"
+	case Failed:
+		return "Failed"
+	case Closing:
+		return "Closing"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// ClosePolicy determines what happens to the playing sounds when a Mixer is
+// closed.
+type ClosePolicy int
+
+const (
+	// ReleaseSounds stops all sounds when the mixer is closed, they are
+	// Stopped afterwards. The next Init starts with no sounds.
+	ReleaseSounds ClosePolicy = iota
+	// KeepSounds keeps all sounds at their current positions when the mixer
+	// is closed. They continue playing after the next Init.
+	KeepSounds
+)
+
+// State returns the current lifecycle state of the mixer.
+func (m *Mixer) State() State {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.state
+}
+
+func (m *Mixer) setState(s State) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.changeState(s)
+}
+
+// changeState sets the state, it must be called with the mixer locked. Only a
+// Running mixer applies the posted commands in its updates, otherwise they
+// are applied right away.
+func (m *Mixer) changeState(s State) {
+	m.state = s
+	if s == Running {
+		atomic.StoreInt32(&m.mixing, 1)
+	} else {
+		atomic.StoreInt32(&m.mixing, 0)
+		m.publish()
+	}
+}
+
+// fail puts a running mixer in the Failed state.
+func (m *Mixer) fail() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.state == Running {
+		m.changeState(Failed)
+	}
+}
+
+// releaseSounds stops all sounds of the mixer.
+func (m *Mixer) releaseSounds() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, s := range m.sounds {
+		s.finish()
+	}
+	m.sounds = nil
+}
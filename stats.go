@@ -0,0 +1,82 @@
+package mixer
+
+import "time"
+
+// Stats are statistics about the mixer's output to the backend. They help to
+// diagnose stuttering sound.
+type Stats struct {
+	// Wakeups is the number of times the mixer polled the backend.
+	Wakeups int
+
+	// Updates is the number of updates in which the backend had played data
+	// and the mixer wrote new data.
+	Updates int
+
+	// Underruns is the number of times that the backend played more data than
+	// the mixer had written ahead. This happens if the mixer's Go routine is
+	// starved, e.g. because of a long GC pause or a slow machine. In this case
+	// the backend plays stale data from its ring buffer.
+	Underruns int
+
+	// LongestUnderrun is the longest time that stale data was played in a
+	// single underrun.
+	LongestUnderrun time.Duration
+
+	// TotalUnderrun is the total time that stale data was played.
+	TotalUnderrun time.Duration
+
+	// AverageJitter is the average deviation of the time that the backend
+	// played between two updates, as measured by the amount of data, from the
+	// time that passed between them. If the mixer is not updated in real time,
+	// e.g. with a NullBackend, the deviation from the configured update
+	// interval is used instead.
+	AverageJitter time.Duration
+}
+
+// stats keeps the running statistics of a Mixer.
+type stats struct {
+	Stats
+	totalJitter time.Duration
+}
+
+// Stats returns the statistics of the mixer's output since Init.
+func (m *Mixer) Stats() Stats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	s := m.stats.Stats
+	if s.Updates > 0 {
+		s.AverageJitter = m.stats.totalJitter / time.Duration(s.Updates)
+	}
+	return s
+}
+
+// countUpdate adds an update in which the backend played the given number of
+// bytes to the statistics, elapsed is the time since the last update or 0 if
+// it is not known.
+func (m *Mixer) countUpdate(playedBytes int, elapsed time.Duration) {
+	s := &m.stats
+	s.Updates++
+
+	played := samplesToDuration(
+		float64(playedBytes/m.frameSize), m.format.SamplesPerSecond)
+	expected := m.updateInterval
+	if m.realTime && elapsed > 0 {
+		expected = elapsed
+	}
+	jitter := played - expected
+	if jitter < 0 {
+		jitter = -jitter
+	}
+	s.totalJitter += jitter
+
+	if stale := playedBytes - len(m.writeAheadBuffer); stale > 0 {
+		underrun := samplesToDuration(
+			float64(stale/m.frameSize), m.format.SamplesPerSecond)
+		s.Underruns++
+		s.TotalUnderrun += underrun
+		if underrun > s.LongestUnderrun {
+			s.LongestUnderrun = underrun
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package mixer
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// The mixer publishes the state that the getters return as immutable views.
+// After every update, it replaces them atomically so the getters never wait
+// for the mixer to finish mixing.
+//
+// Changes made through the API are applied by the mixer later, see post. For
+// the getters to return them right away, the API functions publish views with
+// the expected result of the change. Each change to a sound is numbered and
+// the mixer does not replace a view that expects changes it has not applied
+// yet.
+
+// mixerView is a snapshot of the mixer clock.
+type mixerView struct {
+	// frames is the output frame at the write cursor
+	frames int64
+	// deviceLatency is the number of frames between the backend's play and
+	// write cursors, writeAhead is the number of frames written ahead
+	deviceLatency, writeAhead float64
+	// realTime is true if the clock is extrapolated from lastUpdate
+	realTime   bool
+	lastUpdate time.Time
+	offline    bool
+
+	samplesPerSecond int
+	updateInterval   time.Duration
+}
+
+// audibleDelay returns the number of output frames between the data at the
+// write cursor and the data that is heard right now. It is negative if the
+// backend played past the write cursor since the last update.
+func (v *mixerView) audibleDelay() float64 {
+	if v.offline || v.samplesPerSecond == 0 {
+		return 0
+	}
+	delay := v.deviceLatency
+	if v.realTime {
+		// the backend kept playing since the last update
+		elapsed := time.Since(v.lastUpdate).Seconds()
+		delay -= elapsed * float64(v.samplesPerSecond)
+	}
+	// if the update is late, the backend plays the data that was written
+	// ahead, but not further
+	if delay < -v.writeAhead {
+		delay = -v.writeAhead
+	}
+	return delay
+}
+
+// soundView is a snapshot of a sound's state.
+type soundView struct {
+	// seq is the number of the last change to the sound that the view
+	// includes
+	seq uint64
+	// clock is the mixer clock at the time of the snapshot, it is nil before
+	// the mixer published it
+	clock *mixerView
+
+	paused, stopped bool
+	// frozen is true if the sound does not advance because it or its group
+	// is paused
+	frozen bool
+	// over is true if the sound reached its end
+	over bool
+	// ramped is true if changes to the sound are ramped while it is not
+	// paused, in this case a stopped sound is faded out first
+	ramped   bool
+	fading   bool
+	volume   float32
+	pan      float32
+	pitch    float32
+	loop     int
+	released bool
+	group    *group
+	// pos is the position in source samples at the write cursor, advanced is
+	// the number of frames that the sound played since its position was set,
+	// mixed is the number of frames after the write cursor that it was mixed
+	pos      float64
+	advanced int64
+	mixed    int
+}
+
+// view returns the current snapshot of the mixer clock, it is nil before the
+// mixer was initialized.
+func (m *Mixer) view() *mixerView {
+	return (*mixerView)(atomic.LoadPointer(&m.clockView))
+}
+
+// publish applies all posted commands and publishes the mixer's current
+// state for the getters. It must be called with the mixer locked.
+func (m *Mixer) publish() {
+	m.applyCommands()
+
+	var clock *mixerView
+	if m.frameSize != 0 {
+		clock = &mixerView{
+			frames:           m.frames,
+			deviceLatency:    float64(m.deviceLatency / uint(m.frameSize)),
+			writeAhead:       float64(len(m.leftBuffer)),
+			realTime:         m.realTime,
+			lastUpdate:       m.lastUpdate,
+			offline:          m.offline,
+			samplesPerSecond: m.format.SamplesPerSecond,
+			updateInterval:   m.updateInterval,
+		}
+	}
+	atomic.StorePointer(&m.clockView, unsafe.Pointer(clock))
+
+	for _, s := range m.sounds {
+		s.publish(clock, false)
+	}
+}
+
+// view returns the current snapshot of the sound.
+func (s *sound) view() *soundView {
+	return (*soundView)(atomic.LoadPointer(&s.snapshot))
+}
+
+// publish publishes the sound's current state at the given clock. Unless
+// force is true, it keeps a view that expects changes that are not applied
+// yet. It must be called with the mixer locked.
+func (s *sound) publish(clock *mixerView, force bool) {
+	v := &soundView{
+		seq:      s.applied,
+		clock:    clock,
+		paused:   s.paused,
+		frozen:   s.paused || s.group.isPaused(),
+		stopped:  s.source == nil,
+		ramped:   s.audible && s.mixer.smoothingFrames > 0 && !s.group.isPaused(),
+		volume:   s.volume,
+		pan:      s.pan,
+		pitch:    s.pitch,
+		loop:     s.loop,
+		released: s.released,
+		group:    s.group,
+		pos:      float64(s.loop*s.loopLength()) + s.cursor,
+		advanced: s.advanced,
+		mixed:    s.mixed,
+	}
+	if s.source != nil {
+		v.over = s.isOver()
+		v.fading = s.fading && !s.gain.done(s.mixer.frames)
+	}
+	for {
+		old := atomic.LoadPointer(&s.snapshot)
+		if o := (*soundView)(old); o != nil && o.seq > v.seq {
+			if !force {
+				return
+			}
+			v.seq = o.seq
+		}
+		if atomic.CompareAndSwapPointer(&s.snapshot, old, unsafe.Pointer(v)) {
+			return
+		}
+	}
+}
+
+// change is a change to a sound. The mixer calls apply on the sound, expect
+// modifies a view of the sound to what the getters return after the change.
+type change struct {
+	apply  func(s *sound)
+	expect func(s *sound, v *soundView)
+}
+
+// change posts c to the mixer and publishes the expected view right away.
+// Changes to Stopped sounds are ignored.
+func (s *sound) change(c change) {
+	seq, ok := s.expect(c.expect)
+	if !ok {
+		return
+	}
+	s.mixer.post(func() { s.applyChange(c.apply, seq) })
+}
+
+// expect publishes the view that the given change results in. It returns the
+// number of the change, ok is false if the sound is Stopped.
+func (s *sound) expect(f func(s *sound, v *soundView)) (seq uint64, ok bool) {
+	if s.view().stopped {
+		return 0, false
+	}
+	seq = atomic.AddUint64(&s.posted, 1)
+	for {
+		old := atomic.LoadPointer(&s.snapshot)
+		v := *(*soundView)(old)
+		if v.seq < seq {
+			v.seq = seq
+		}
+		if f != nil {
+			f(s, &v)
+		}
+		if atomic.CompareAndSwapPointer(&s.snapshot, old, unsafe.Pointer(&v)) {
+			return seq, true
+		}
+	}
+}
+
+// applyChange applies the change with the given number. It must be called
+// with the mixer locked.
+func (s *sound) applyChange(apply func(s *sound), seq uint64) {
+	if s.source != nil {
+		apply(s)
+	}
+	if seq > s.applied {
+		s.applied = seq
+	}
+	if s.source == nil {
+		// the mixer does not publish Stopped sounds anymore
+		s.publish(s.mixer.view(), true)
+	}
+}
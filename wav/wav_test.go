@@ -47,7 +47,7 @@ func TestFormattingErrors(t *testing.T) {
 
 	for _, test := range tests {
 		r := bytes.NewReader(test.input)
-		_, err := Load(r)
+		_, err := Read(r)
 
 		if test.shouldFail && err == nil {
 			t.Error(test.name, "- error expected")
@@ -0,0 +1,111 @@
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Writer writes sound data in the WAV format. The data is written as it comes
+// in, the sizes in the file header are only fixed up when Close is called.
+// This is why a Writer needs an io.WriteSeeker, e.g. an *os.File.
+type Writer struct {
+	w        io.WriteSeeker
+	dataSize uint32
+	closed   bool
+}
+
+// NewWriter writes the WAV header for uncompressed PCM data with the given
+// format to w. Call Write on the returned Writer to write interleaved sound
+// data and Close when you are done to complete the file.
+func NewWriter(w io.WriteSeeker, channelCount, samplesPerSecond, bitsPerSample int) (*Writer, error) {
+	return newWriter(w, pcmFormat, channelCount, samplesPerSecond, bitsPerSample)
+}
+
+// NewFloatWriter is like NewWriter but for 32 bit IEEE floating point samples.
+func NewFloatWriter(w io.WriteSeeker, channelCount, samplesPerSecond int) (*Writer, error) {
+	return newWriter(w, ieeeFormat, channelCount, samplesPerSecond, 32)
+}
+
+func newWriter(w io.WriteSeeker, tag formatCode, channelCount, samplesPerSecond, bitsPerSample int) (*Writer, error) {
+	if channelCount <= 0 || samplesPerSecond <= 0 || bitsPerSample <= 0 ||
+		bitsPerSample%8 != 0 {
+		return nil, errors.New("write WAV: illegal format")
+	}
+
+	blockAlignment := channelCount * bitsPerSample / 8
+	header := struct {
+		waveHeader
+		formatHeader chunkHeader
+		format       formatChunkBase
+		dataHeader   chunkHeader
+	}{
+		waveHeader: waveHeader{
+			chunkHeader: chunkHeader{ChunkID: riffChunkID},
+			WaveID:      waveChunkID,
+		},
+		formatHeader: chunkHeader{ChunkID: formatChunkID, ChunkSize: 16},
+		format: formatChunkBase{
+			FormatTag:      tag,
+			Channels:       uint16(channelCount),
+			SamplesPerSec:  uint32(samplesPerSecond),
+			AvgBytesPerSec: uint32(samplesPerSecond * blockAlignment),
+			BlockAlignment: uint16(blockAlignment),
+			BitsPerSample:  uint16(bitsPerSample),
+		},
+		dataHeader: chunkHeader{ChunkID: dataChunkID},
+	}
+	if err := binary.Write(w, endiannes, &header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w}, nil
+}
+
+// Write appends the given sound data to the data chunk.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write WAV: writer is closed")
+	}
+	n, err := w.w.Write(p)
+	w.dataSize += uint32(n)
+	return n, err
+}
+
+// Close pads the data chunk if necessary and writes the final sizes to the
+// header. It does not close the underlying io.WriteSeeker. After Close, the
+// position in the io.WriteSeeker is at the end of the WAV file.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	padding := w.dataSize % 2
+	if padding == 1 {
+		if _, err := w.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	// the RIFF chunk size at offset 4 contains "WAVE", the format chunk and
+	// the data chunk
+	riffSize := 4 + (8 + 16) + (8 + w.dataSize + padding)
+	if err := w.writeAt(4, riffSize); err != nil {
+		return err
+	}
+	// the data chunk's size is right before the data at offset 40
+	if err := w.writeAt(40, w.dataSize); err != nil {
+		return err
+	}
+
+	_, err := w.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+func (w *Writer) writeAt(offset int64, value uint32) error {
+	if _, err := w.w.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	return binary.Write(w.w, endiannes, value)
+}
@@ -0,0 +1,83 @@
+package wav
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestWrittenWaveCanBeReadBack(t *testing.T) {
+	var file memoryFile
+	w, err := NewWriter(&file, 2, 22050, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte{1, 2, 3})
+	w.Write([]byte{4, 5, 6, 7, 8})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wave, err := Read(bytes.NewReader(file.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wave.ChannelCount != 2 ||
+		wave.SamplesPerSecond != 22050 ||
+		wave.BitsPerSample != 16 {
+		t.Error("wrong format", wave)
+	}
+	checkBytes(t, wave.Data, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+}
+
+func TestOddDataSizeIsPadded(t *testing.T) {
+	var file memoryFile
+	w, err := NewWriter(&file, 1, 44100, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte{1, 2, 3})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(file.data) != 44+4 {
+		t.Fatal("wrong file size", len(file.data))
+	}
+	wave, err := Read(bytes.NewReader(file.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBytes(t, wave.Data, []byte{1, 2, 3})
+}
+
+// memoryFile is an in-memory io.WriteSeeker.
+type memoryFile struct {
+	data   []byte
+	offset int
+}
+
+func (f *memoryFile) Write(p []byte) (int, error) {
+	for len(f.data) < f.offset+len(p) {
+		f.data = append(f.data, 0)
+	}
+	copy(f.data[f.offset:], p)
+	f.offset += len(p)
+	return len(p), nil
+}
+
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.offset = int(offset)
+	case io.SeekCurrent:
+		f.offset += int(offset)
+	case io.SeekEnd:
+		f.offset = len(f.data) + int(offset)
+	}
+	if f.offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	return int64(f.offset), nil
+}